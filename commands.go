@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/poblish/boulevard/generation"
+)
+
+type inputFiles []string
+
+func (i *inputFiles) String() string {
+	return "my string representation"
+}
+
+func (i *inputFiles) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+// boulevard rules sync --rulerUrl http://mimir:8080 --tenant team-a [--rulesFile ruler_rules.yaml] [--dryRun]
+func rulesSync(args []string, state BoulevardState) {
+	var files inputFiles
+	var rulerUrl string
+	var tenant string
+	var token string
+	var dryRun bool
+
+	flags := flag.NewFlagSet("rules sync", flag.ExitOnError)
+	flags.Var(&files, "rulesFile", "Ruler namespace file(s) to sync")
+	flags.StringVar(&rulerUrl, "rulerUrl", state.RulerUrl, "Ruler base URL")
+	flags.StringVar(&tenant, "tenant", state.RulerTenant, "Ruler tenant (X-Scope-OrgID)")
+	flags.StringVar(&token, "token", os.Getenv("BOULEVARD_RULER_TOKEN"), "Ruler bearer token")
+	flags.BoolVar(&dryRun, "dryRun", false, "Only report the changes that would be made")
+	_ = flags.Parse(args)
+
+	if rulerUrl == "" {
+		log.Fatalf("No ruler URL specified")
+	}
+
+	if len(files) == 0 {
+		files = []string{defaultRulesOutputPath(state, rulerOutputFormat)}
+	}
+
+	var namespaces []generation.RulerNamespace
+	for _, each := range files {
+		namespace, err := generation.ReadRulerNamespace(each)
+		if err != nil {
+			log.Fatalf("Could not read rules %s", err)
+		}
+		namespaces = append(namespaces, namespace)
+	}
+
+	client := &generation.RulerClient{Address: rulerUrl, Tenant: tenant, BearerToken: token}
+
+	changes, err := generation.SyncRulerNamespaces(client, namespaces, dryRun)
+	for _, each := range changes {
+		if dryRun {
+			fmt.Println("[DRY RUN] Would", each)
+		} else {
+			fmt.Println("Done:", each)
+		}
+	}
+
+	if err != nil {
+		log.Fatalf("Rules sync failed %s", err)
+	}
+
+	if len(changes) == 0 {
+		fmt.Println("Ruler already up to date")
+	}
+}
+
+// boulevard dashboard push --grafanaUrl http://grafana:3000 [--dashboardFile grafana_dashboard.json] [--folder Payments] [--overwrite] [--message "..."]
+func dashboardPush(args []string, state BoulevardState) {
+	var files inputFiles
+	var grafanaUrl string
+	var token string
+	var folder string
+	var overwrite bool
+	var message string
+	var format string
+	var helmTemplate bool
+
+	flags := flag.NewFlagSet("dashboard push", flag.ExitOnError)
+	flags.Var(&files, "dashboardFile", "Dashboard file(s) to push, as written by generation")
+	flags.StringVar(&format, "dashboardFormat", state.DashboardFormat, "Format the dashboards were written as: json (default), configmap or grafanadashboard")
+	flags.BoolVar(&helmTemplate, "dashboardHelmTemplate", state.DashboardHelmTemplate, "The dashboards were written as Helm templates")
+	flags.StringVar(&grafanaUrl, "grafanaUrl", state.GrafanaUrl, "Grafana base URL")
+	flags.StringVar(&token, "token", os.Getenv("BOULEVARD_GRAFANA_TOKEN"), "Grafana service account token")
+	flags.StringVar(&folder, "folder", state.DashboardFolder, "Grafana folder, created if need be")
+	flags.BoolVar(&overwrite, "overwrite", false, "Replace dashboards even if changed in Grafana meanwhile, or with the title of another")
+	flags.StringVar(&message, "message", "", "Message for the dashboard version history")
+	_ = flags.Parse(args)
+
+	if grafanaUrl == "" {
+		log.Fatalf("No Grafana URL specified")
+	}
+
+	if len(files) == 0 {
+		// The main dashboard, and any it was split into, wherever generation writes them
+		defaultPath, chartTemplate := defaultDashboardPath(state, format)
+		helmTemplate = helmTemplate || chartTemplate
+		splitPaths, _ := filepath.Glob(strings.TrimSuffix(defaultPath, filepath.Ext(defaultPath)) + "_*" + filepath.Ext(defaultPath))
+		files = append([]string{defaultPath}, splitPaths...)
+	}
+
+	resource := generation.DashboardResourceOptions{Format: format, HelmTemplate: helmTemplate}
+
+	client := &generation.GrafanaClient{Address: grafanaUrl, BearerToken: token}
+	opts := generation.DashboardPushOptions{Folder: folder, Overwrite: overwrite, Message: message}
+
+	for _, each := range files {
+		dashboard, err := generation.ReadDashboardFile(each, resource)
+		if err != nil {
+			log.Fatalf("Could not read dashboard %s", err)
+		}
+
+		push, err := client.PushDashboard(dashboard, opts)
+		if err != nil {
+			log.Fatalf("Dashboard push failed %s", err)
+		}
+
+		fmt.Println("Done:", push)
+	}
+}
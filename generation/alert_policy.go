@@ -0,0 +1,103 @@
+package generation
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// AlertPolicy restricts the routing labels and content of generated alerts, e.g.
+//
+//	allowedSeverities: [pager, warning]
+//	allowedTeams: [payments]
+//	requiredAnnotations: [summary]
+//	rules:
+//	- when: {severity: pager}
+//	  requiredAnnotations: [runbook_url]
+type AlertPolicy struct {
+	AllowedSeverities   []string                 `yaml:"allowedSeverities"`
+	AllowedTeams        []string                 `yaml:"allowedTeams"`
+	RequiredLabels      []string                 `yaml:"requiredLabels"`
+	RequiredAnnotations []string                 `yaml:"requiredAnnotations"`
+	Rules               []ConditionalAlertPolicy `yaml:"rules"`
+}
+
+// ConditionalAlertPolicy applies extra requirements to alerts whose labels match all of `when`
+type ConditionalAlertPolicy struct {
+	When                map[string]string `yaml:"when"`
+	RequiredLabels      []string          `yaml:"requiredLabels"`
+	RequiredAnnotations []string          `yaml:"requiredAnnotations"`
+}
+
+func LoadAlertPolicy(filePath string) (*AlertPolicy, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := AlertPolicy{}
+	if err := yaml.UnmarshalStrict(data, &policy); err != nil {
+		return nil, fmt.Errorf("bad alert policy %s: %v", filePath, err)
+	}
+
+	return &policy, nil
+}
+
+// violations lists every way in which the alert breaks the policy
+func (p *AlertPolicy) violations(alertName string, labels map[string]string, annotations map[string]string) []string {
+	var problems []string
+
+	if severity := labels["severity"]; len(p.AllowedSeverities) > 0 && !containsString(p.AllowedSeverities, severity) {
+		problems = append(problems, fmt.Sprintf("alert %s has severity %q, expected one of %v", alertName, severity, p.AllowedSeverities))
+	}
+
+	if team := labels["team"]; len(p.AllowedTeams) > 0 && !containsString(p.AllowedTeams, team) {
+		problems = append(problems, fmt.Sprintf("alert %s has team %q, expected one of %v", alertName, team, p.AllowedTeams))
+	}
+
+	problems = append(problems, missingValues(alertName, "label", p.RequiredLabels, labels, "")...)
+	problems = append(problems, missingValues(alertName, "annotation", p.RequiredAnnotations, annotations, "")...)
+
+	for _, each := range p.Rules {
+		if !each.matches(labels) {
+			continue
+		}
+
+		reason := " (required when " + each.describeCondition() + ")"
+		problems = append(problems, missingValues(alertName, "label", each.RequiredLabels, labels, reason)...)
+		problems = append(problems, missingValues(alertName, "annotation", each.RequiredAnnotations, annotations, reason)...)
+	}
+
+	return problems
+}
+
+func (c ConditionalAlertPolicy) matches(labels map[string]string) bool {
+	for k, v := range c.When {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (c ConditionalAlertPolicy) describeCondition() string {
+	var conditions []string
+	for k, v := range c.When {
+		conditions = append(conditions, k+"="+v)
+	}
+	sort.Strings(conditions)
+	return strings.Join(conditions, ", ")
+}
+
+func missingValues(alertName string, kind string, required []string, values map[string]string, reason string) []string {
+	var problems []string
+	for _, each := range required {
+		if strings.TrimSpace(values[each]) == "" {
+			problems = append(problems, fmt.Sprintf("alert %s has no %s %s%s", alertName, kind, each, reason))
+		}
+	}
+	return problems
+}
@@ -19,11 +19,19 @@ type RuleGenerator struct {
 const (
 	PrometheusAlertManagerFormat = iota
 	PrometheusOperatorFormat
+	PrometheusRuleResourceFormat
+	GrafanaAlertingFormat
+	RulerNamespaceFormat
 )
 
 type OutputOptions struct {
 	AlertRuleFormat int
 	ExtraLabels     []string
+	PrometheusRule  PrometheusRuleOptions
+	GrafanaAlerting GrafanaAlertingOptions
+	Ruler           RulerOptions
+	Policy          *AlertPolicy
+	Dashboard       DashboardLinkOptions
 }
 
 func (rg *RuleGenerator) processAlertAnnotations(commentGroup *ast.CommentGroup) error {
@@ -54,72 +62,57 @@ func (rg *RuleGenerator) postProcess(destFilePath string, metricPrefix string, m
 
 	var alertEntries []AlertRuleOutput
 	var operatorAlertEntries []PrometheusOperatorAlertRuleOutput
+	var grafanaAlertEntries []GrafanaAlertRule
 
 	switch options.AlertRuleFormat {
 	case PrometheusAlertManagerFormat:
 		alertEntries = make([]AlertRuleOutput, len(rg.alertRules))
-	case PrometheusOperatorFormat:
+	case PrometheusOperatorFormat, PrometheusRuleResourceFormat, RulerNamespaceFormat:
 		operatorAlertEntries = make([]PrometheusOperatorAlertRuleOutput, len(rg.alertRules))
+	case GrafanaAlertingFormat:
+		grafanaAlertEntries = make([]GrafanaAlertRule, len(rg.alertRules))
 	}
 
-	var displayPrefix string
-	if rg.defaults != nil && rg.defaults.displayPrefix != "" {
-		displayPrefix = rg.defaults.displayPrefix
-	} else {
-		displayPrefix = strings.Title(defaultDisplayPrefix)
-	}
-
-	if displayPrefix == "" {
-		displayPrefix = "Application"
-	}
-
-	displayPrefix = prefixNormalizer.Replace(displayPrefix)
+	displayPrefix := rg.displayPrefix(defaultDisplayPrefix)
 
 	metrics := AlertMetrics{Count: len(rg.alertRules)}
 
+	var policyViolations []string
+	grafanaRuleUids := make(map[string]string) // alert name, by rule UID
+
 	for i, eachRule := range rg.alertRules {
 
-		ruleProps := eachRule.properties()
+		ruleProps := rg.resolvedProperties(eachRule)
 
-		if rg.defaults != nil {
-			if _, ok := ruleProps["team"]; !ok {
-				ruleProps["team"] = rg.defaults.team
+		if eachRule.errorType() != "" {
+			var normalisedMetricName string
+			if false { // FIXME dg.caseSensitiveMetricNames {
+				normalisedMetricName = normalizer.Replace(eachRule.errorType())
+			} else {
+				normalisedMetricName = normaliseAndLowercaseName(eachRule.errorType())
 			}
 
-			if _, ok := ruleProps["severity"]; !ok {
-				ruleProps["severity"] = rg.defaults.severity
+			var alertMetricFqn string
+			if multiplePrefixesFound {
+				alertMetricFqn = normalisedMetricName
+			} else {
+				alertMetricFqn = metricPrefix + normalisedMetricName
 			}
-		}
 
-		var normalisedMetricName string
-		if false { // FIXME dg.caseSensitiveMetricNames {
-			normalisedMetricName = normalizer.Replace(ruleProps["errorLabel"])
-		} else {
-			normalisedMetricName = normaliseAndLowercaseName(ruleProps["errorLabel"])
-		}
-
-		var alertMetricFqn string
-		if multiplePrefixesFound {
-			alertMetricFqn = normalisedMetricName
-		} else {
-			alertMetricFqn = metricPrefix + normalisedMetricName
-		}
-
-		// Validate errorLabel is an actual metric name
-		if _, ok := fqnsInUse[alertMetricFqn]; !ok {
-			return metrics, fmt.Errorf("alert refers to missing metric %s", alertMetricFqn)
+			// Validate errorLabel is an actual metric name
+			if _, ok := fqnsInUse[alertMetricFqn]; !ok {
+				return metrics, fmt.Errorf("alert refers to missing metric %s", alertMetricFqn)
+			}
 		}
 
-		alertName := displayPrefix + strings.Title(ruleProps["name"])
+		alertName := ruleAlertName(displayPrefix, eachRule)
 
 		labels := make(map[string]string)
-		labels["severity"] = ruleProps["severity"] // FIXME check blank
-		labels["team"] = ruleProps["team"]         // FIXME check blank
+		labels["severity"] = ruleProps["severity"]
+		labels["team"] = ruleProps["team"]
 
-		for _, each := range options.ExtraLabels {
-			idx := strings.Index(each, "=")
-			k := strings.TrimSpace(each[:idx])
-			labels[k] = strings.TrimSpace(each[idx+1:])
+		for k, v := range keyValuePairs(options.ExtraLabels) {
+			labels[k] = v
 		}
 
 		annotations := make(map[string]string)
@@ -143,29 +136,73 @@ func (rg *RuleGenerator) postProcess(destFilePath string, metricPrefix string, m
 			return metrics, fmt.Errorf("no summary or description for alert %s", alertName)
 		}
 
-		expr, err := eachRule.alertRuleExpression(metricPrefix)
+		options.Dashboard.annotate(annotations, eachRule.panelMetricName(metricPrefix))
+
+		if options.Policy != nil {
+			policyViolations = append(policyViolations, options.Policy.violations(alertName, labels, annotations)...)
+		}
+
+		expr, err := alertRuleExpression(eachRule, metricPrefix)
 		if err != nil {
 			return metrics, err
 		}
 
+		// Otherwise the alert is routed to nobody
+		for _, routingLabel := range []string{"team", "severity"} {
+			if strings.TrimSpace(labels[routingLabel]) == "" {
+				return metrics, fmt.Errorf("no %s for alert %s", routingLabel, alertName)
+			}
+		}
+
 		switch options.AlertRuleFormat {
 		case PrometheusAlertManagerFormat:
 			alertEntries[i] = AlertRuleOutput{Alert: alertName, Expr: expr, Duration: ruleProps["duration"], Labels: labels, Annotations: annotations}
-		case PrometheusOperatorFormat:
+		case PrometheusOperatorFormat, PrometheusRuleResourceFormat, RulerNamespaceFormat:
 			operatorAlertEntries[i] = PrometheusOperatorAlertRuleOutput{Alert: alertName, Expr: expr, For: ruleProps["duration"], Labels: labels, Annotations: annotations}
+		case GrafanaAlertingFormat:
+			threshold, _ := eachRule.alertRuleThreshold() // already validated above
+			grafanaAlertEntries[i], err = options.GrafanaAlerting.rule(alertName, eachRule.alertRuleQuery(metricPrefix), threshold,
+				ruleProps["timeRange"], ruleProps["duration"], eachRule.panelMetricName(metricPrefix), labels, annotations)
+			if err != nil {
+				return metrics, fmt.Errorf("bad threshold for alert %s: %v", alertName, err)
+			}
+
+			uid := grafanaAlertEntries[i].Uid
+			if other, ok := grafanaRuleUids[uid]; ok {
+				return metrics, fmt.Errorf("alerts %s and %s have the same Grafana rule UID %s", other, alertName, uid)
+			}
+			grafanaRuleUids[uid] = alertName
 		}
 	}
 
+	if len(policyViolations) > 0 {
+		return metrics, fmt.Errorf("alert policy violated:\n  %s", strings.Join(policyViolations, "\n  "))
+	}
+
 	var alertRulesSpec interface{}
 
+	groupName := displayPrefix + " auto-generated alerts"
+	operatorSpec := PrometheusOperatorRulesSpec{Groups: []PrometheusOperatorAlertRulesGroup{{Name: groupName, Rules: operatorAlertEntries}}}
+
 	switch options.AlertRuleFormat {
 	case PrometheusAlertManagerFormat:
-		alertRulesSpec = AlertRulesGroup{Name: displayPrefix + " auto-generated alerts", Rules: alertEntries}
+		alertRulesSpec = AlertRulesGroup{Name: groupName, Rules: alertEntries}
 	case PrometheusOperatorFormat:
-		alertRulesSpec = PrometheusOperatorRulesSpec{Groups: []PrometheusOperatorAlertRulesGroup{{Name: displayPrefix + " auto-generated alerts", Rules: operatorAlertEntries}}}
+		alertRulesSpec = operatorSpec
+	case GrafanaAlertingFormat:
+		alertRulesSpec = options.GrafanaAlerting.ruleGroup(displayPrefix, groupName, grafanaAlertEntries)
+	case RulerNamespaceFormat:
+		alertRulesSpec = options.Ruler.namespace(displayPrefix, operatorSpec.Groups)
 	}
 
-	data, err := yaml.Marshal(&alertRulesSpec)
+	var data []byte
+	var err error
+
+	if options.AlertRuleFormat == PrometheusRuleResourceFormat {
+		data, err = options.PrometheusRule.render(displayPrefix, operatorSpec)
+	} else {
+		data, err = yaml.Marshal(&alertRulesSpec)
+	}
 	if err != nil {
 		return metrics, fmt.Errorf("alert marshalling error: %v", err)
 	}
@@ -184,6 +221,38 @@ func (rg *RuleGenerator) postProcess(destFilePath string, metricPrefix string, m
 	return metrics, err
 }
 
+func (rg *RuleGenerator) displayPrefix(defaultDisplayPrefix string) string {
+	var displayPrefix string
+	if rg.defaults != nil && rg.defaults.displayPrefix != "" {
+		displayPrefix = rg.defaults.displayPrefix
+	} else {
+		displayPrefix = strings.Title(defaultDisplayPrefix)
+	}
+
+	if displayPrefix == "" {
+		displayPrefix = "Application"
+	}
+
+	return prefixNormalizer.Replace(displayPrefix)
+}
+
+// resolvedProperties are the rule's own properties, with any team and severity from the alert defaults filled in
+func (rg *RuleGenerator) resolvedProperties(rule AlertRule) map[string]string {
+	ruleProps := rule.properties()
+
+	if rg.defaults != nil {
+		if _, ok := ruleProps["team"]; !ok {
+			ruleProps["team"] = rg.defaults.team
+		}
+
+		if _, ok := ruleProps["severity"]; !ok {
+			ruleProps["severity"] = rg.defaults.severity
+		}
+	}
+
+	return ruleProps
+}
+
 func (rg *RuleGenerator) parseZeroToleranceErrorAlertRule(comment string) {
 	props := make(map[string]string)
 	props["timeRange"] = "1m"
@@ -220,6 +289,19 @@ func extractPayload(comment string) string {
 	return comment[strings.Index(comment, "(")+1 : strings.Index(comment, ")")]
 }
 
+// keyValuePairs parses `key=value` entries, as passed on the command line or in the state file
+func keyValuePairs(entries []string) map[string]string {
+	pairs := make(map[string]string)
+	for _, each := range entries {
+		idx := strings.Index(each, "=")
+		if idx < 0 {
+			continue
+		}
+		pairs[strings.TrimSpace(each[:idx])] = strings.TrimSpace(each[idx+1:])
+	}
+	return pairs
+}
+
 func parsePayload(payload string, props map[string]string) {
 	for _, val := range strings.Split(extractPayload(payload), ",") {
 		parts := strings.Split(val, "=")
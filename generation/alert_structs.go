@@ -3,6 +3,7 @@ package generation
 import (
 	"fmt"
 	"strconv"
+	"strings"
 )
 
 type AlertDefaults struct {
@@ -14,7 +15,23 @@ type AlertDefaults struct {
 
 type AlertRule interface {
 	properties() map[string]string
-	alertRuleExpression(metricPrefix string) (string, error)
+	alertRuleQuery(metricPrefix string) string
+	alertRuleThreshold() (string, error)
+	errorType() string                          // blank unless the rule is on a particular type of error
+	panelMetricName(metricPrefix string) string // full name of the metric, or series, whose panel best shows the rule, if any
+}
+
+func ruleAlertName(displayPrefix string, rule AlertRule) string {
+	return displayPrefix + strings.Title(rule.properties()["name"])
+}
+
+// alertRuleExpression combines a rule's query and threshold into a PromQL alerting expression
+func alertRuleExpression(rule AlertRule, metricPrefix string) (string, error) {
+	threshold, err := rule.alertRuleThreshold()
+	if err != nil {
+		return "", err
+	}
+	return rule.alertRuleQuery(metricPrefix) + " > " + threshold, nil
 }
 
 type ZeroToleranceErrorAlertRule struct {
@@ -26,8 +43,20 @@ func (r ZeroToleranceErrorAlertRule) properties() map[string]string {
 	return r.props
 }
 
-func (r ZeroToleranceErrorAlertRule) alertRuleExpression(metricPrefix string) (string, error) {
-	return "sum(rate(" + metricPrefix + "errors{error_type='" + r.props["errorLabel"] + "'}[" + r.props["timeRange"] + "])) > 0", nil
+func (r ZeroToleranceErrorAlertRule) alertRuleQuery(metricPrefix string) string {
+	return "sum(rate(" + metricPrefix + "errors{error_type='" + r.props["errorLabel"] + "'}[" + r.props["timeRange"] + "]))"
+}
+
+func (r ZeroToleranceErrorAlertRule) alertRuleThreshold() (string, error) {
+	return "0", nil
+}
+
+func (r ZeroToleranceErrorAlertRule) errorType() string {
+	return r.props["errorLabel"]
+}
+
+func (r ZeroToleranceErrorAlertRule) panelMetricName(metricPrefix string) string {
+	return errorTypeSeries(metricPrefix, r.errorType())
 }
 
 type ElevatedErrorRateAlertRule struct {
@@ -39,14 +68,98 @@ func (r ElevatedErrorRateAlertRule) properties() map[string]string {
 	return r.props
 }
 
-func (r ElevatedErrorRateAlertRule) alertRuleExpression(metricPrefix string) (string, error) {
+func (r ElevatedErrorRateAlertRule) alertRuleQuery(metricPrefix string) string {
+	return "sum(rate(" + metricPrefix + "errors{error_type='" + r.props["errorLabel"] + "'}[" + r.props["timeRange"] + "]))"
+}
+
+func (r ElevatedErrorRateAlertRule) alertRuleThreshold() (string, error) {
 	unvalidatedRate := r.props["ratePerSecondThreshold"]
 	_, err := strconv.ParseFloat(unvalidatedRate, 64)
 	if err != nil {
 		return "", fmt.Errorf("bad ratePerSecondThreshold: %v", err)
 	}
 
-	return "sum(rate(" + metricPrefix + "errors{error_type='" + r.props["errorLabel"] + "'}[" + r.props["timeRange"] + "])) > " + unvalidatedRate, nil
+	return unvalidatedRate, nil
+}
+
+func (r ElevatedErrorRateAlertRule) errorType() string {
+	return r.props["errorLabel"]
+}
+
+func (r ElevatedErrorRateAlertRule) panelMetricName(metricPrefix string) string {
+	return errorTypeSeries(metricPrefix, r.errorType())
+}
+
+// ExternalMetricAlertRule alerts on an external metric family
+type ExternalMetricAlertRule struct {
+	AlertRule
+	props  map[string]string
+	family ExternalMetricFamily
+}
+
+func (r ExternalMetricAlertRule) properties() map[string]string {
+	return r.props
+}
+
+func (r ExternalMetricAlertRule) alertRuleQuery(metricPrefix string) string {
+	filter := strings.TrimSuffix(r.family.filter(), ",")
+	timeRange := r.props["timeRange"]
+
+	switch r.family.Type {
+	case "counter":
+		return fmt.Sprintf("sum(rate(%s{%s}[%s]))", r.family.Name, filter, timeRange)
+	case "gauge":
+		return fmt.Sprintf("max(%s{%s})", r.family.Name, filter)
+	case "histogram":
+		return fmt.Sprintf("histogram_quantile(0.99, sum(rate(%s_bucket{%s}[%s])) by (le))", r.family.Name, filter, timeRange)
+	}
+	return fmt.Sprintf(`max(%s{%s})`, r.family.Name, strings.TrimPrefix(filter+`,quantile="0.99"`, ","))
+}
+
+func (r ExternalMetricAlertRule) alertRuleThreshold() (string, error) {
+	threshold := r.family.Alert.Threshold
+	if _, err := strconv.ParseFloat(threshold, 64); err != nil {
+		return "", fmt.Errorf("bad threshold for external metric %s: %v", r.family.Name, err)
+	}
+	return threshold, nil
+}
+
+func (r ExternalMetricAlertRule) errorType() string {
+	return ""
+}
+
+func (r ExternalMetricAlertRule) panelMetricName(metricPrefix string) string {
+	return r.family.series()
+}
+
+// RuntimeAlertRule alerts on a Go runtime or process metric, with a fixed query
+type RuntimeAlertRule struct {
+	AlertRule
+	props       map[string]string
+	query       string
+	threshold   string
+	panel       string
+	serviceDown bool // Suppresses the service's error alerts while it fires
+}
+
+func (r RuntimeAlertRule) properties() map[string]string {
+	return r.props
+}
+
+func (r RuntimeAlertRule) alertRuleQuery(metricPrefix string) string {
+	return r.query
+}
+
+func (r RuntimeAlertRule) alertRuleThreshold() (string, error) {
+	return r.threshold, nil
+}
+
+func (r RuntimeAlertRule) errorType() string {
+	return ""
+}
+
+func (r RuntimeAlertRule) panelMetricName(metricPrefix string) string {
+	return r.panel
 }
 
 // ====================================================================================
@@ -0,0 +1,248 @@
+package generation
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const DefaultReceiverNameFormat = "{team}-{severity}"
+
+// DefaultSeverityOrder ranks severities from most to least urgent, for inhibition
+var DefaultSeverityOrder = []string{"pager", "critical", "error", "warning", "info"}
+
+// DefaultInhibitEqual keeps an alert from one cluster suppressing those from another on a shared Alertmanager
+var DefaultInhibitEqual = []string{"cluster"}
+
+// AlertmanagerOptions configures the Alertmanager `route` subtree and `inhibit_rules` generated for the alert rules
+type AlertmanagerOptions struct {
+	ExtraLabels           []string // key=value, as added to every rule, and matched by the top-level route
+	GroupBy               []string // Defaults to [alertname]
+	RepeatIntervals       []string // severity=interval
+	DefaultRepeatInterval string
+	ReceiverNameFormat    string   // Defaults to DefaultReceiverNameFormat
+	SeverityOrder         []string // Defaults to DefaultSeverityOrder
+	InhibitEqual          []string // Labels an alert must share with those it suppresses. Defaults to DefaultInhibitEqual.
+}
+
+// AlertmanagerConfig is the part of alertmanager.yml generated from the rules, to be merged into the main configuration
+type AlertmanagerConfig struct {
+	Route        AlertmanagerRoute         `yaml:"route"`
+	InhibitRules []AlertmanagerInhibitRule `yaml:"inhibit_rules,omitempty"`
+}
+
+type AlertmanagerRoute struct {
+	Receiver       string              `yaml:"receiver,omitempty"`
+	Matchers       []string            `yaml:"matchers,omitempty"`
+	GroupBy        []string            `yaml:"group_by,omitempty"`
+	RepeatInterval string              `yaml:"repeat_interval,omitempty"`
+	Routes         []AlertmanagerRoute `yaml:"routes,omitempty"`
+}
+
+type AlertmanagerInhibitRule struct {
+	SourceMatchers []string `yaml:"source_matchers"`
+	TargetMatchers []string `yaml:"target_matchers"`
+	Equal          []string `yaml:"equal,omitempty"`
+}
+
+func (dg *DashboardGenerator) GenerateAlertmanagerConfig(destFilePath string, options AlertmanagerOptions) error {
+	config, err := dg.RuleGenerator.alertmanagerConfig(dg.currentMetricPrefix, options)
+	if err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(&config)
+	if err != nil {
+		return fmt.Errorf("alertmanager config marshalling error: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destFilePath), os.ModePerm); err != nil {
+		return fmt.Errorf("output directory creation failed: %v", err)
+	}
+
+	fmt.Println("Writing Alertmanager config to", FriendlyFileName(destFilePath))
+
+	return os.WriteFile(destFilePath, data, 0644)
+}
+
+func (rg *RuleGenerator) alertmanagerConfig(defaultDisplayPrefix string, options AlertmanagerOptions) (AlertmanagerConfig, error) {
+	displayPrefix := rg.displayPrefix(defaultDisplayPrefix)
+
+	receiverFormat := options.ReceiverNameFormat
+	if receiverFormat == "" {
+		receiverFormat = DefaultReceiverNameFormat
+	}
+
+	groupBy := options.GroupBy
+	if len(groupBy) == 0 {
+		groupBy = []string{"alertname"}
+	}
+
+	repeatIntervals := keyValuePairs(options.RepeatIntervals)
+
+	// team -> severities, both in first-seen order
+	var teams []string
+	severitiesByTeam := make(map[string][]string)
+
+	for _, eachRule := range rg.alertRules {
+		ruleProps := rg.resolvedProperties(eachRule)
+		team, severity := ruleProps["team"], ruleProps["severity"]
+
+		if team == "" || severity == "" {
+			return AlertmanagerConfig{}, fmt.Errorf("cannot route alert %s with blank team or severity", displayPrefix+strings.Title(ruleProps["name"]))
+		}
+
+		if _, ok := severitiesByTeam[team]; !ok {
+			teams = append(teams, team)
+		}
+		if !containsString(severitiesByTeam[team], severity) {
+			severitiesByTeam[team] = append(severitiesByTeam[team], severity)
+		}
+	}
+
+	config := AlertmanagerConfig{Route: AlertmanagerRoute{Matchers: matchers(keyValuePairs(options.ExtraLabels))}}
+
+	for _, team := range teams {
+		teamRoute := AlertmanagerRoute{Matchers: []string{matcher("team", team)}, GroupBy: groupBy}
+
+		for _, severity := range severitiesByTeam[team] {
+			repeatInterval, ok := repeatIntervals[severity]
+			if !ok {
+				repeatInterval = options.DefaultRepeatInterval
+			}
+
+			receiver := strings.NewReplacer("{team}", team, "{severity}", severity).Replace(receiverFormat)
+			teamRoute.Routes = append(teamRoute.Routes, AlertmanagerRoute{Receiver: receiver, Matchers: []string{matcher("severity", severity)}, RepeatInterval: repeatInterval})
+		}
+
+		config.Route.Routes = append(config.Route.Routes, teamRoute)
+	}
+
+	config.InhibitRules = rg.inhibitRules(displayPrefix, options)
+
+	return config, nil
+}
+
+// inhibitRules has a service being down suppress all of its error alerts, and the more urgent of several alerts on
+// the same error type suppress the less urgent ones
+func (rg *RuleGenerator) inhibitRules(displayPrefix string, options AlertmanagerOptions) []AlertmanagerInhibitRule {
+	severityOrder := options.SeverityOrder
+	if len(severityOrder) == 0 {
+		severityOrder = DefaultSeverityOrder
+	}
+
+	equal := options.InhibitEqual
+	if len(equal) == 0 {
+		equal = DefaultInhibitEqual
+	}
+
+	severityRank := func(severity string) int {
+		for i, each := range severityOrder {
+			if each == severity {
+				return i
+			}
+		}
+		return -1
+	}
+
+	type namedRule struct {
+		name     string
+		severity string
+	}
+
+	var errorAlertNames []string
+	var serviceDownAlertNames []string
+	var errorTypes []string
+	rulesByErrorType := make(map[string][]namedRule)
+
+	for _, eachRule := range rg.alertRules {
+		ruleProps := rg.resolvedProperties(eachRule)
+		alertName := displayPrefix + strings.Title(ruleProps["name"])
+
+		if runtimeRule, ok := eachRule.(RuntimeAlertRule); ok && runtimeRule.serviceDown {
+			serviceDownAlertNames = append(serviceDownAlertNames, alertName)
+		}
+
+		if errorType := eachRule.errorType(); errorType != "" {
+			errorAlertNames = append(errorAlertNames, alertName)
+
+			if _, ok := rulesByErrorType[errorType]; !ok {
+				errorTypes = append(errorTypes, errorType)
+			}
+			rulesByErrorType[errorType] = append(rulesByErrorType[errorType], namedRule{name: alertName, severity: ruleProps["severity"]})
+		}
+	}
+
+	var inhibitRules []AlertmanagerInhibitRule
+
+	if len(errorAlertNames) > 0 {
+		for _, eachDown := range serviceDownAlertNames {
+			inhibitRules = append(inhibitRules, AlertmanagerInhibitRule{
+				SourceMatchers: []string{matcher("alertname", eachDown)},
+				TargetMatchers: []string{regexMatcher("alertname", errorAlertNames)},
+				Equal:          equal,
+			})
+		}
+	}
+
+	for _, errorType := range errorTypes {
+		rules := rulesByErrorType[errorType]
+
+		for _, source := range rules {
+			var targets []string
+			for _, target := range rules {
+				if severityRank(source.severity) >= 0 && severityRank(target.severity) > severityRank(source.severity) {
+					targets = append(targets, target.name)
+				}
+			}
+
+			if len(targets) > 0 {
+				inhibitRules = append(inhibitRules, AlertmanagerInhibitRule{
+					SourceMatchers: []string{matcher("alertname", source.name)},
+					TargetMatchers: []string{regexMatcher("alertname", targets)},
+					Equal:          append([]string{"team"}, equal...),
+				})
+			}
+		}
+	}
+
+	return inhibitRules
+}
+
+func matcher(name string, value string) string {
+	return fmt.Sprintf("%s=%q", name, value)
+}
+
+func regexMatcher(name string, values []string) string {
+	if len(values) == 1 {
+		return matcher(name, values[0])
+	}
+	return fmt.Sprintf("%s=~%q", name, strings.Join(values, "|"))
+}
+
+func matchers(labels map[string]string) []string {
+	var names []string
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var result []string
+	for _, each := range names {
+		result = append(result, matcher(each, labels[each]))
+	}
+	return result
+}
+
+func containsString(values []string, value string) bool {
+	for _, each := range values {
+		if each == value {
+			return true
+		}
+	}
+	return false
+}
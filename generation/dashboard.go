@@ -0,0 +1,502 @@
+package generation
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Dashboard is the subset of the Grafana dashboard JSON model that boulevard generates
+// https://grafana.com/docs/grafana/latest/dashboards/build-dashboards/view-dashboard-json-model/
+type Dashboard struct {
+	Annotations   AnnotationList  `json:"annotations"`
+	Description   string          `json:"description,omitempty"`
+	Editable      bool            `json:"editable"`
+	GnetId        *int            `json:"gnetId"`
+	GraphTooltip  int             `json:"graphTooltip"`
+	Id            *int            `json:"id"`
+	Links         []DashboardLink `json:"links"`
+	Panels        []*Panel        `json:"panels"`
+	Refresh       interface{}     `json:"refresh"` // false, or an interval string
+	SchemaVersion int             `json:"schemaVersion"`
+	Style         string          `json:"style,omitempty"`
+	Tags          []string        `json:"tags"`
+	Templating    Templating      `json:"templating"`
+	Time          TimeRange       `json:"time"`
+	Timepicker    Timepicker      `json:"timepicker"`
+	Timezone      string          `json:"timezone"`
+	Title         string          `json:"title"`
+	Uid           string          `json:"uid"`
+	Version       int             `json:"version"`
+}
+
+type AnnotationList struct {
+	List []Annotation `json:"list"`
+}
+
+type Annotation struct {
+	BuiltIn     int               `json:"builtIn,omitempty"`
+	Datasource  interface{}       `json:"datasource"`
+	Enable      bool              `json:"enable"`
+	Expr        string            `json:"expr,omitempty"`
+	Filter      *AnnotationFilter `json:"filter,omitempty"`
+	Hide        bool              `json:"hide"`
+	IconColor   string            `json:"iconColor"`
+	Name        string            `json:"name"`
+	Step        string            `json:"step,omitempty"`
+	TextFormat  string            `json:"textFormat,omitempty"`
+	TitleFormat string            `json:"titleFormat,omitempty"`
+	Type        string            `json:"type,omitempty"`
+}
+
+// AnnotationFilter limits an annotation to the given panels
+type AnnotationFilter struct {
+	Exclude bool  `json:"exclude"`
+	Ids     []int `json:"ids"`
+}
+
+type DashboardLink struct {
+	Title       string   `json:"title"`
+	Type        string   `json:"type"` // "link" or "dashboards"
+	Url         string   `json:"url,omitempty"`
+	Tags        []string `json:"tags"`
+	AsDropdown  bool     `json:"asDropdown"`
+	IncludeVars bool     `json:"includeVars"`
+	KeepTime    bool     `json:"keepTime"`
+	TargetBlank bool     `json:"targetBlank"`
+	Icon        string   `json:"icon,omitempty"`
+	Tooltip     string   `json:"tooltip,omitempty"`
+}
+
+type Templating struct {
+	List []*TemplateVariable `json:"list"`
+}
+
+type TemplateVariable struct {
+	Name       string        `json:"name"`
+	Label      string        `json:"label,omitempty"`
+	Type       string        `json:"type"`
+	Datasource interface{}   `json:"datasource,omitempty"`
+	Query      interface{}   `json:"query"`
+	Definition string        `json:"definition,omitempty"`
+	Refresh    int           `json:"refresh,omitempty"`
+	Multi      bool          `json:"multi"`
+	IncludeAll bool          `json:"includeAll"`
+	AllValue   string        `json:"allValue,omitempty"`
+	Current    interface{}   `json:"current"`
+	Hide       int           `json:"hide"`
+	Options    []interface{} `json:"options"`
+	Sort       int           `json:"sort,omitempty"`
+}
+
+type TimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type Timepicker struct {
+	RefreshIntervals []string `json:"refresh_intervals"`
+	TimeOptions      []string `json:"time_options"`
+}
+
+type GridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type Panel struct {
+	Boulevard       *PanelOwnership  `json:"boulevard,omitempty"` // Only for generated panels
+	Collapsed       *bool            `json:"collapsed,omitempty"` // Only for rows
+	Datasource      interface{}      `json:"datasource,omitempty"`
+	FieldConfig     *FieldConfig     `json:"fieldConfig,omitempty"`
+	GridPos         GridPos          `json:"gridPos"`
+	Id              int              `json:"id"`
+	Links           []PanelLink      `json:"links,omitempty"`
+	Options         interface{}      `json:"options,omitempty"`
+	Panels          []*Panel         `json:"panels,omitempty"` // Only for collapsed rows
+	Targets         []Target         `json:"targets,omitempty"`
+	Title           string           `json:"title"`
+	Transformations []Transformation `json:"transformations,omitempty"`
+	Type            string           `json:"type"`
+
+	*GraphPanel // Only for the legacy "graph" type
+}
+
+type PanelLink struct {
+	Title       string `json:"title"`
+	Url         string `json:"url"`
+	TargetBlank bool   `json:"targetBlank"`
+}
+
+type Target struct {
+	Expr           string `json:"expr"`
+	Format         string `json:"format,omitempty"`
+	Instant        bool   `json:"instant,omitempty"`
+	Exemplar       bool   `json:"exemplar,omitempty"`
+	IntervalFactor int    `json:"intervalFactor,omitempty"`
+	LegendFormat   string `json:"legendFormat,omitempty"`
+	RefId          string `json:"refId"`
+}
+
+type Transformation struct {
+	Id      string      `json:"id"`
+	Options interface{} `json:"options"`
+}
+
+type DataSourceRef struct {
+	Type string `json:"type"`
+	Uid  string `json:"uid"`
+}
+
+type FieldConfig struct {
+	Defaults  FieldDefaults   `json:"defaults"`
+	Overrides []FieldOverride `json:"overrides"`
+}
+
+type FieldDefaults struct {
+	Unit       string                 `json:"unit,omitempty"`
+	Min        *float64               `json:"min,omitempty"`
+	Max        *float64               `json:"max,omitempty"`
+	Decimals   *int                   `json:"decimals,omitempty"`
+	Color      *FieldColor            `json:"color,omitempty"`
+	Thresholds *Thresholds            `json:"thresholds,omitempty"`
+	Custom     map[string]interface{} `json:"custom,omitempty"`
+	Links      []DataLink             `json:"links,omitempty"`
+}
+
+type FieldOverride struct {
+	Matcher    FieldMatcher    `json:"matcher"`
+	Properties []FieldProperty `json:"properties"`
+}
+
+type FieldMatcher struct {
+	Id      string `json:"id"`
+	Options string `json:"options"`
+}
+
+type FieldProperty struct {
+	Id    string      `json:"id"`
+	Value interface{} `json:"value"`
+}
+
+type FieldColor struct {
+	Mode string `json:"mode"`
+}
+
+type Thresholds struct {
+	Mode  string          `json:"mode"`
+	Steps []ThresholdStep `json:"steps"`
+}
+
+type ThresholdStep struct {
+	Color string   `json:"color"`
+	Value *float64 `json:"value"` // null for the base step
+}
+
+// GraphPanel holds the properties of the legacy (pre-Grafana 7) "graph" panel
+type GraphPanel struct {
+	Bars            bool                `json:"bars"`
+	DashLength      int                 `json:"dashLength"`
+	Dashes          bool                `json:"dashes"`
+	Fill            int                 `json:"fill"`
+	Legend          GraphLegend         `json:"legend"`
+	Lines           bool                `json:"lines"`
+	Linewidth       int                 `json:"linewidth"`
+	Percentage      bool                `json:"percentage"`
+	Pointradius     int                 `json:"pointradius"`
+	Points          bool                `json:"points"`
+	SeriesOverrides []interface{}       `json:"seriesOverrides"`
+	SpaceLength     int                 `json:"spaceLength"`
+	Stack           bool                `json:"stack"`
+	Thresholds      []interface{}       `json:"thresholds"`
+	TimeFrom        *string             `json:"timeFrom"`
+	TimeRegions     []interface{}       `json:"timeRegions"`
+	TimeShift       *string             `json:"timeShift"`
+	Tooltip         GraphTooltip        `json:"tooltip"`
+	Xaxis           GraphXAxis          `json:"xaxis"`
+	Yaxes           []GraphYAxis        `json:"yaxes"`
+	Yaxis           GraphYAxisAlignment `json:"yaxis"`
+}
+
+type GraphLegend struct {
+	Avg     bool `json:"avg"`
+	Current bool `json:"current"`
+	Max     bool `json:"max"`
+	Min     bool `json:"min"`
+	Show    bool `json:"show"`
+	Total   bool `json:"total"`
+	Values  bool `json:"values"`
+}
+
+type GraphTooltip struct {
+	Shared    bool   `json:"shared"`
+	Sort      int    `json:"sort"`
+	ValueType string `json:"value_type"`
+}
+
+type GraphXAxis struct {
+	Buckets interface{}   `json:"buckets"`
+	Mode    string        `json:"mode"`
+	Name    interface{}   `json:"name"`
+	Show    bool          `json:"show"`
+	Values  []interface{} `json:"values"`
+}
+
+type GraphYAxis struct {
+	Format  string      `json:"format"`
+	Label   *string     `json:"label"`
+	LogBase int         `json:"logBase"`
+	Max     interface{} `json:"max"`
+	Min     interface{} `json:"min"`
+	Show    bool        `json:"show"`
+}
+
+type GraphYAxisAlignment struct {
+	Align      bool        `json:"align"`
+	AlignLevel interface{} `json:"alignLevel"`
+}
+
+const DefaultSchemaVersion = 39 // Grafana 11
+
+const LegacySchemaVersion = 16
+
+// Grafana 7.4 introduced "timeseries" panels, and field config for all panels. Older targets get legacy "graph" panels.
+const firstTimeseriesSchemaVersion = 27
+
+const legacyDashboardId = 26
+
+// Legacy panels name their datasource, here the datasource variable
+const legacyDatasource = "$" + datasourceVariable
+
+func newDashboard(title string, uid string, tags []string, schemaVersion int) *Dashboard {
+	if tags == nil {
+		tags = []string{}
+	}
+
+	if schemaVersion >= firstTimeseriesSchemaVersion {
+		return &Dashboard{
+			Annotations: AnnotationList{List: []Annotation{{
+				BuiltIn: 1, Datasource: DataSourceRef{Type: "grafana", Uid: "-- Grafana --"}, Enable: true, Hide: true, IconColor: "rgba(0, 211, 255, 1)", Name: "Annotations & Alerts", Type: "dashboard",
+			}}},
+			Editable:      true,
+			Links:         []DashboardLink{},
+			Panels:        []*Panel{},
+			Refresh:       "",
+			SchemaVersion: schemaVersion,
+			Tags:          tags,
+			Templating:    Templating{List: []*TemplateVariable{}},
+			Time:          TimeRange{From: "now/d", To: "now"},
+			Timepicker: Timepicker{
+				RefreshIntervals: []string{"5s", "10s", "30s", "1m", "5m", "15m", "30m", "1h", "2h", "1d"},
+				TimeOptions:      []string{"5m", "15m", "1h", "6h", "12h", "24h", "2d", "7d", "30d"},
+			},
+			Title:   title,
+			Uid:     uid,
+			Version: 1,
+		}
+	}
+
+	id := legacyDashboardId
+
+	return &Dashboard{
+		Annotations: AnnotationList{List: []Annotation{{
+			BuiltIn: 1, Datasource: "-- Grafana --", Enable: true, Hide: true, IconColor: "rgba(0, 211, 255, 1)", Name: "Annotations & Alerts", Type: "dashboard",
+		}}},
+		Editable:      true,
+		Id:            &id,
+		Links:         []DashboardLink{},
+		Panels:        []*Panel{},
+		Refresh:       false,
+		SchemaVersion: schemaVersion,
+		Style:         "dark",
+		Tags:          tags,
+		Templating:    Templating{List: []*TemplateVariable{}},
+		Time:          TimeRange{From: "now/d", To: "now"},
+		Timepicker: Timepicker{
+			RefreshIntervals: []string{"5s", "10s", "30s", "1m", "5m", "15m", "30m", "1h", "2h", "1d"},
+			TimeOptions:      []string{"5m", "15m", "1h", "6h", "12h", "24h", "2d", "7d", "30d"},
+		},
+		Title:   title,
+		Uid:     uid,
+		Version: 1,
+	}
+}
+
+func newGraphPanel(datasource string, title string, unit string, targets ...Target) *Panel {
+	return &Panel{
+		Datasource: datasource,
+		GridPos:    GridPos{H: 9, W: 12},
+		Targets:    targets,
+		Title:      title,
+		Type:       "graph",
+		GraphPanel: &GraphPanel{
+			DashLength:      10,
+			Fill:            1,
+			Legend:          GraphLegend{Show: true},
+			Lines:           true,
+			Linewidth:       1,
+			Pointradius:     5,
+			SeriesOverrides: []interface{}{},
+			SpaceLength:     10,
+			Thresholds:      []interface{}{},
+			TimeRegions:     []interface{}{},
+			Tooltip:         GraphTooltip{Shared: true, ValueType: "individual"},
+			Xaxis:           GraphXAxis{Mode: "time", Show: true, Values: []interface{}{}},
+			Yaxes:           []GraphYAxis{{Format: unit, LogBase: 1, Show: true}, {Format: unit, LogBase: 1, Show: true}},
+		},
+	}
+}
+
+func newTimeseriesPanel(datasource DataSourceRef, title string, defaults FieldDefaults, targets ...Target) *Panel {
+	defaults.Color = &FieldColor{Mode: "palette-classic"}
+	defaults.Custom = map[string]interface{}{
+		"drawStyle":         "line",
+		"lineInterpolation": "linear",
+		"lineWidth":         1,
+		"fillOpacity":       10,
+		"showPoints":        "never",
+		"spanNulls":         false,
+		"axisPlacement":     "auto",
+	}
+
+	return &Panel{
+		Datasource:  datasource,
+		FieldConfig: &FieldConfig{Defaults: withBaseThresholds(defaults), Overrides: []FieldOverride{}},
+		GridPos:     GridPos{H: 9, W: 12},
+		Options: map[string]interface{}{
+			"legend":  map[string]interface{}{"displayMode": "list", "placement": "bottom", "showLegend": true, "calcs": []string{}},
+			"tooltip": map[string]interface{}{"mode": "multi", "sort": "none"},
+		},
+		Targets: targets,
+		Title:   title,
+		Type:    "timeseries",
+	}
+}
+
+func newStatPanel(datasource DataSourceRef, title string, defaults FieldDefaults, targets ...Target) *Panel {
+	defaults.Color = &FieldColor{Mode: "thresholds"}
+
+	return &Panel{
+		Datasource:  datasource,
+		FieldConfig: &FieldConfig{Defaults: withBaseThresholds(defaults), Overrides: []FieldOverride{}},
+		GridPos:     GridPos{H: 9, W: 12},
+		Options: map[string]interface{}{
+			"reduceOptions": lastValueReduceOptions(),
+			"colorMode":     "value",
+			"graphMode":     "area",
+			"justifyMode":   "auto",
+			"orientation":   "auto",
+			"textMode":      "auto",
+		},
+		Targets: targets,
+		Title:   title,
+		Type:    "stat",
+	}
+}
+
+func newGaugePanel(datasource DataSourceRef, title string, defaults FieldDefaults, targets ...Target) *Panel {
+	defaults.Color = &FieldColor{Mode: "thresholds"}
+
+	return &Panel{
+		Datasource:  datasource,
+		FieldConfig: &FieldConfig{Defaults: withBaseThresholds(defaults), Overrides: []FieldOverride{}},
+		GridPos:     GridPos{H: 9, W: 12},
+		Options: map[string]interface{}{
+			"reduceOptions":        lastValueReduceOptions(),
+			"orientation":          "auto",
+			"showThresholdLabels":  false,
+			"showThresholdMarkers": true,
+		},
+		Targets: targets,
+		Title:   title,
+		Type:    "gauge",
+	}
+}
+
+func newBarGaugePanel(datasource DataSourceRef, title string, defaults FieldDefaults, targets ...Target) *Panel {
+	defaults.Color = &FieldColor{Mode: "continuous-GrYlRd"}
+
+	return &Panel{
+		Datasource:  datasource,
+		FieldConfig: &FieldConfig{Defaults: withBaseThresholds(defaults), Overrides: []FieldOverride{}},
+		GridPos:     GridPos{H: 9, W: 12},
+		Options: map[string]interface{}{
+			"reduceOptions": lastValueReduceOptions(),
+			"displayMode":   "gradient",
+			"orientation":   "horizontal",
+			"showUnfilled":  true,
+		},
+		Targets: targets,
+		Title:   title,
+		Type:    "bargauge",
+	}
+}
+
+// newHeatmapPanel shows pre-bucketed (`le`) histogram data
+func newHeatmapPanel(datasource DataSourceRef, title string, unit string, targets ...Target) *Panel {
+	return &Panel{
+		Datasource:  datasource,
+		FieldConfig: &FieldConfig{Defaults: FieldDefaults{Custom: map[string]interface{}{"hideFrom": map[string]bool{"legend": false, "tooltip": false, "viz": false}}}, Overrides: []FieldOverride{}},
+		GridPos:     GridPos{H: 9, W: 12},
+		Options: map[string]interface{}{
+			"calculate":    false,
+			"cellGap":      1,
+			"color":        map[string]interface{}{"mode": "scheme", "scheme": "Oranges", "exponent": 0.5, "steps": 64},
+			"yAxis":        map[string]interface{}{"axisPlacement": "left", "unit": unit},
+			"rowsFrame":    map[string]interface{}{"layout": "auto"},
+			"tooltip":      map[string]interface{}{"mode": "single", "yHistogram": false},
+			"legend":       map[string]interface{}{"show": true},
+			"showValue":    "never",
+			"filterValues": map[string]interface{}{"le": 1e-9},
+		},
+		Targets: targets,
+		Title:   title,
+		Type:    "heatmap",
+	}
+}
+
+func newTablePanel(datasource DataSourceRef, title string, defaults FieldDefaults, targets ...Target) *Panel {
+	return &Panel{
+		Datasource:  datasource,
+		FieldConfig: &FieldConfig{Defaults: withBaseThresholds(defaults), Overrides: []FieldOverride{}},
+		GridPos:     GridPos{H: 9, W: 12},
+		Options: map[string]interface{}{
+			"showHeader": true,
+			"footer":     map[string]interface{}{"show": false, "reducer": []string{"sum"}},
+		},
+		Targets: targets,
+		Title:   title,
+		Type:    "table",
+	}
+}
+
+func lastValueReduceOptions() map[string]interface{} {
+	return map[string]interface{}{"calcs": []string{"lastNotNull"}, "fields": "", "values": false}
+}
+
+func withBaseThresholds(defaults FieldDefaults) FieldDefaults {
+	if defaults.Thresholds == nil {
+		defaults.Thresholds = &Thresholds{Mode: "absolute", Steps: []ThresholdStep{{Color: "green"}}}
+	}
+	return defaults
+}
+
+// MarshalDashboard renders the dashboard as indented JSON, leaving PromQL operators such as `>` unescaped
+func MarshalDashboard(dashboard *Dashboard) ([]byte, error) {
+	return marshalIndented(dashboard)
+}
+
+func marshalIndented(value interface{}) ([]byte, error) {
+	buf := bytes.Buffer{}
+
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "\t")
+
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
@@ -0,0 +1,260 @@
+package generation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"text/template"
+)
+
+const (
+	defaultTraceQuery = `{ resource.service.name =~ "${job:regex}" }`
+	defaultLogQuery   = `{{.Selector}}`
+)
+
+// Grafana's own variables, which must survive URL encoding for it to interpolate them
+var escapedLinkVariable = regexp.MustCompile(`%24%7B[A-Za-z0-9_.]+(%3A[A-Za-z0-9_]+)?%7D`)
+
+var openingBrackets = map[rune]rune{'}': '{', ')': '(', ']': '['}
+
+var (
+	streamMatcher = regexp.MustCompile(`([A-Za-z_][A-Za-z0-9_]*)\s*(=~|!~|!=|=)\s*("(?:[^"\\]|\\.)*"|` + "`[^`]*`)")
+	linkVariable  = regexp.MustCompile(`\$\{[^}]+\}`)
+)
+
+// DataLinkOptions link panels to traces in Tempo and logs in Loki
+type DataLinkOptions struct {
+	TempoDatasourceUid string // Adds a traces link to timer and histogram panels, and exemplars to histogram and counter ones
+	LokiDatasourceUid  string // Adds a logs link to every panel
+	TraceQuery         string // TraceQL template, given .Metric and .Selector. Defaults to the service name matching the job.
+	LogQuery           string // LogQL template, given .Metric and .Selector. Defaults to the selector.
+}
+
+type DataLink struct {
+	Title       string `json:"title"`
+	Url         string `json:"url"`
+	TargetBlank bool   `json:"targetBlank"`
+}
+
+type dataLinkQueryData struct {
+	Metric   string
+	Selector string // The dashboard's variables as a stream selector, e.g. `{namespace=~"${namespace:regex}",...,job=~".+"}`
+}
+
+// dataLinkTemplates are the parsed queries, blank unless their datasource is configured
+type dataLinkTemplates struct {
+	traces *template.Template
+	logs   *template.Template
+}
+
+func (dg *DashboardGenerator) dataLinkTemplates() (dataLinkTemplates, error) {
+	var templates dataLinkTemplates
+	var err error
+
+	if dg.DataLinks.TempoDatasourceUid != "" {
+		if templates.traces, err = parseDataLinkQuery("trace", dg.DataLinks.TempoDatasourceUid, stringOrDefault(dg.DataLinks.TraceQuery, defaultTraceQuery)); err != nil {
+			return templates, err
+		}
+	}
+
+	if dg.DataLinks.LokiDatasourceUid != "" {
+		if templates.logs, err = parseDataLinkQuery("log", dg.DataLinks.LokiDatasourceUid, stringOrDefault(dg.DataLinks.LogQuery, defaultLogQuery)); err != nil {
+			return templates, err
+		}
+
+		rendered, _ := renderDataLinkQuery(templates.logs, dataLinkQueryData{Metric: "metric", Selector: dataLinkSelector()})
+		if !hasNonEmptyMatcher(rendered) {
+			return templates, fmt.Errorf("bad log query %q: Loki needs a matcher that can't match an empty value, even with every variable at All", rendered)
+		}
+	}
+
+	return templates, nil
+}
+
+// parseDataLinkQuery checks the datasource UID, and that the query renders to something with a balanced selector
+func parseDataLinkQuery(kind string, datasourceUid string, query string) (*template.Template, error) {
+	if len(datasourceUid) > 40 || invalidGrafanaUidChars.MatchString(datasourceUid) {
+		return nil, fmt.Errorf("bad %s datasource UID %q", kind, datasourceUid)
+	}
+
+	tmpl, err := template.New(kind).Option("missingkey=error").Parse(query)
+	if err != nil {
+		return nil, fmt.Errorf("bad %s query: %v", kind, err)
+	}
+
+	rendered, err := renderDataLinkQuery(tmpl, dataLinkQueryData{Metric: "metric", Selector: dataLinkSelector()})
+	if err != nil {
+		return nil, fmt.Errorf("bad %s query: %v", kind, err)
+	}
+
+	if err := checkSelector(rendered); err != nil {
+		return nil, fmt.Errorf("bad %s query %q: %v", kind, rendered, err)
+	}
+	return tmpl, nil
+}
+
+func renderDataLinkQuery(tmpl *template.Template, data dataLinkQueryData) (string, error) {
+	buf := bytes.Buffer{}
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// checkSelector wants the query to start with a selector, and its brackets and quotes to balance
+func checkSelector(query string) error {
+	if len(query) == 0 || query[0] != '{' {
+		return fmt.Errorf("expected it to start with a {selector}")
+	}
+
+	var open []rune
+	var quote rune
+
+	for i, each := range query {
+		switch {
+		case quote != 0:
+			if each == quote && (i == 0 || query[i-1] != '\\') {
+				quote = 0
+			}
+		case each == '"' || each == '`':
+			quote = each
+		case each == '{' || each == '(' || each == '[':
+			open = append(open, each)
+		case each == '}' || each == ')' || each == ']':
+			if len(open) == 0 || openingBrackets[each] != open[len(open)-1] {
+				return fmt.Errorf("unexpected %c", each)
+			}
+			open = open[:len(open)-1]
+		}
+	}
+
+	if quote != 0 {
+		return fmt.Errorf("unterminated string")
+	}
+	if len(open) > 0 {
+		return fmt.Errorf("unclosed %c", open[len(open)-1])
+	}
+	return nil
+}
+
+// dataLinkSelector matches the dashboard's variables, in the formats Grafana interpolates links with. The variables all
+// match empty values when set to All, which Loki refuses, so there must be a job.
+func dataLinkSelector() string {
+	selector := "{"
+	for _, each := range standardVariables {
+		selector += fmt.Sprintf(`%s=~"${%s:regex}",`, each, each)
+	}
+	return selector + `job=~".+"}`
+}
+
+// hasNonEmptyMatcher looks in the query's stream selector for a matcher Loki accepts on its own, taking each variable
+// to be at All
+func hasNonEmptyMatcher(query string) bool {
+	end := -1
+	var quote rune
+	for i, each := range query {
+		if quote != 0 {
+			if each == quote && query[i-1] != '\\' {
+				quote = 0
+			}
+		} else if each == '"' || each == '`' {
+			quote = each
+		} else if each == '}' {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return false
+	}
+
+	for _, each := range streamMatcher.FindAllStringSubmatch(query[:end], -1) {
+		value, err := strconv.Unquote(each[3])
+		if err != nil {
+			continue
+		}
+
+		switch each[2] {
+		case "=":
+			if value != "" {
+				return true
+			}
+		case "=~":
+			re, err := regexp.Compile("^(?:" + linkVariable.ReplaceAllString(value, ".*") + ")$")
+			if err == nil && !re.MatchString("") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// addDataLinks gives the panel its traces and logs links, and exemplars for histograms and counters. Timers are
+// summaries, which have no exemplars.
+func (dg *DashboardGenerator) addDataLinks(templates dataLinkTemplates, panel *Panel, m *metric) error {
+	if panel.FieldConfig == nil {
+		return nil
+	}
+
+	data := dataLinkQueryData{Metric: m.FullMetricName, Selector: dataLinkSelector()}
+
+	if templates.traces != nil && (m.MetricType == "histogram" || m.MetricType == "counter") {
+		for i := range panel.Targets {
+			panel.Targets[i].Exemplar = true
+		}
+	}
+
+	if templates.traces != nil && (m.MetricType == "timer" || m.MetricType == "histogram") {
+
+		link, err := exploreLink(templates.traces, data, "Traces", "tempo", dg.DataLinks.TempoDatasourceUid, "query", jsonObject{"queryType": "traceql"})
+		if err != nil {
+			return err
+		}
+		panel.FieldConfig.Defaults.Links = append(panel.FieldConfig.Defaults.Links, link)
+	}
+
+	if templates.logs != nil {
+		link, err := exploreLink(templates.logs, data, "Logs", "loki", dg.DataLinks.LokiDatasourceUid, "expr", nil)
+		if err != nil {
+			return err
+		}
+		panel.FieldConfig.Defaults.Links = append(panel.FieldConfig.Defaults.Links, link)
+	}
+
+	return nil
+}
+
+// exploreLink opens the query in Explore, over the panel's time range
+func exploreLink(tmpl *template.Template, data dataLinkQueryData, title string, datasourceType string, datasourceUid string, queryField string, extra jsonObject) (DataLink, error) {
+	query, err := renderDataLinkQuery(tmpl, data)
+	if err != nil {
+		return DataLink{}, err
+	}
+
+	target := jsonObject{"refId": "A", "datasource": DataSourceRef{Type: datasourceType, Uid: datasourceUid}, queryField: query}
+	for k, v := range extra {
+		target[k] = v
+	}
+
+	left, err := json.Marshal(jsonObject{
+		"datasource": datasourceUid,
+		"queries":    []jsonObject{target},
+		"range":      jsonObject{"from": "${__from}", "to": "${__to}"},
+	})
+	if err != nil {
+		return DataLink{}, err
+	}
+
+	link := "/explore?left=" + escapedLinkVariable.ReplaceAllStringFunc(url.QueryEscape(string(left)), func(variable string) string {
+		unescaped, _ := url.QueryUnescape(variable)
+		return unescaped
+	})
+	if _, err := url.Parse(link); err != nil {
+		return DataLink{}, fmt.Errorf("bad %s link: %v", title, err)
+	}
+
+	return DataLink{Title: title, Url: link, TargetBlank: true}, nil
+}
@@ -0,0 +1,143 @@
+package generation
+
+import (
+	"fmt"
+)
+
+const topErrorTypes = 10
+
+// errorPanels shows the rate of all errors, the most common error types, then each error type that has an alert
+func (dg *DashboardGenerator) errorPanels(m *metric) []layoutEntry {
+	errors := &metric{MetricsPrefix: m.MetricsPrefix, FullMetricName: m.MetricsPrefix + "errors"}
+
+	entries := []layoutEntry{{panel: dg.errorRatePanel(errors), key: errors.FullMetricName + "/errors", metricNames: []string{errors.FullMetricName}}}
+
+	if !dg.legacyPanels() {
+		entries = append(entries, layoutEntry{panel: dg.topErrorTypesPanel(errors), key: errors.FullMetricName + "/topk"})
+	}
+
+	for _, errorType := range dg.alertedErrorTypes() {
+		series := errorTypeSeries(m.MetricsPrefix, errorType)
+		entries = append(entries, layoutEntry{panel: dg.alertedErrorTypePanel(errors, errorType), key: series + "/alerts", metricNames: []string{series}})
+	}
+
+	return entries
+}
+
+func (dg *DashboardGenerator) errorRatePanel(errors *metric) *Panel {
+	expr := fmt.Sprintf("sum(rate(%s%s[$__rate_interval])) by (error_type)", errors.FullMetricName, dg.metricSelector(errors))
+	title := "Errors by type (per second)"
+
+	if dg.legacyPanels() {
+		return newGraphPanel(legacyDatasource, title, "cps", Target{Expr: expr, IntervalFactor: 1, LegendFormat: "{{error_type}}", RefId: "A"})
+	}
+	return withAxisLabel(newTimeseriesPanel(dg.datasource(), title, FieldDefaults{Unit: "cps", Min: floatPtr(0)}, Target{Expr: expr, LegendFormat: "{{error_type}}", RefId: "A"}), "per second")
+}
+
+// topErrorTypesPanel tabulates the most frequent errors over the dashboard's time range
+func (dg *DashboardGenerator) topErrorTypesPanel(errors *metric) *Panel {
+	expr := fmt.Sprintf("topk(%d, sum(increase(%s%s[$__range])) by (error_type))", topErrorTypes, errors.FullMetricName, dg.metricSelector(errors))
+
+	panel := newTablePanel(dg.datasource(), "Top error types", FieldDefaults{Unit: defaultUnit, Decimals: intPtr(0)}, Target{Expr: expr, Format: "table", Instant: true, RefId: "A"})
+	panel.Options.(map[string]interface{})["sortBy"] = []map[string]interface{}{{"displayName": "Errors", "desc": true}}
+	panel.Transformations = []Transformation{{Id: "organize", Options: map[string]interface{}{
+		"excludeByName": map[string]bool{"Time": true},
+		"renameByName":  map[string]string{"error_type": "Error type", "Value": "Errors"},
+	}}}
+	return panel
+}
+
+// alertedErrorTypePanel shows the error type's rate, to go against the thresholds of its alerts. The rate is their own
+// query, with one target for each time range they use, so that it's what the thresholds apply to.
+func (dg *DashboardGenerator) alertedErrorTypePanel(errors *metric, errorType string) *Panel {
+	title := fmt.Sprintf("Errors: %s (per second)", errorType)
+	targets := dg.alertTargets(errors.MetricsPrefix, errorType)
+
+	if dg.legacyPanels() {
+		for i := range targets {
+			targets[i].IntervalFactor = 1
+		}
+		return newGraphPanel(legacyDatasource, title, "cps", targets...)
+	}
+
+	return withAxisLabel(newTimeseriesPanel(dg.datasource(), title, FieldDefaults{Unit: "cps", Min: floatPtr(0)}, targets...), "per second")
+}
+
+// alertTargets are the distinct queries of the error type's alerts, in the order the alerts are declared
+func (dg *DashboardGenerator) alertTargets(metricPrefix string, errorType string) []Target {
+	var targets []Target
+	var queries []string
+	for _, each := range dg.alertRules {
+		if each.errorType() != errorType {
+			continue
+		}
+
+		query := each.alertRuleQuery(metricPrefix)
+		if containsString(queries, query) {
+			continue
+		}
+
+		queries = append(queries, query)
+		legend := fmt.Sprintf("%s over %s", errorType, each.properties()["timeRange"])
+		targets = append(targets, Target{Expr: query, LegendFormat: legend, RefId: string(rune('A' + len(targets)))})
+	}
+	return targets
+}
+
+// alertedErrorTypes lists the error types with alerts, in the order their alerts are declared
+func (dg *DashboardGenerator) alertedErrorTypes() []string {
+	var errorTypes []string
+	for _, each := range dg.alertRules {
+		if errorType := each.errorType(); errorType != "" && !containsString(errorTypes, errorType) {
+			errorTypes = append(errorTypes, errorType)
+		}
+	}
+	return errorTypes
+}
+
+// alertAnnotations mark when each error alert was firing, on its error type's panel. Dashboards without that panel,
+// such as the other parts of a split dashboard, get no annotation.
+func (dg *DashboardGenerator) alertAnnotations() []Annotation {
+	displayPrefix := dg.displayPrefix(dg.currentMetricPrefix)
+
+	var annotations []Annotation
+	for _, each := range dg.alertRules {
+		if each.errorType() == "" {
+			continue
+		}
+
+		panelId, ok := dg.panelIds[errorTypeSeries(dg.currentMetricPrefix, each.errorType())]
+		if !ok {
+			continue
+		}
+
+		alertName := ruleAlertName(displayPrefix, each)
+		annotation := Annotation{
+			Datasource:  dg.datasource(),
+			Enable:      true,
+			Expr:        fmt.Sprintf(`ALERTS{alertname="%s",alertstate="firing"}`, alertName),
+			IconColor:   "red",
+			Name:        alertName + " firing",
+			Step:        "60s",
+			TitleFormat: alertName,
+		}
+
+		if dg.legacyPanels() {
+			annotation.Datasource = legacyDatasource
+		} else {
+			annotation.Filter = &AnnotationFilter{Ids: []int{panelId}}
+		}
+
+		annotations = append(annotations, annotation)
+	}
+	return annotations
+}
+
+// errorTypeSeries names the errors of one type, for linking alerts to their panel
+func errorTypeSeries(metricPrefix string, errorType string) string {
+	return metricPrefix + "errors{error_type='" + errorType + "'}"
+}
+
+func intPtr(value int) *int {
+	return &value
+}
@@ -0,0 +1,117 @@
+package generation
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DashboardLinkOptions points alerts at the dashboard panels that show them
+type DashboardLinkOptions struct {
+	GrafanaUrl   string         // Base URL, or blank for URLs relative to the Grafana root
+	DashboardUid string         // Blank if no dashboard was generated
+	PanelIds     map[string]int // by the full name of the metric, or series, each shows
+
+	PanelDashboardUids map[string]string // by the same names, for panels on another of the dashboards
+}
+
+func (o DashboardLinkOptions) dashboardUrl(dashboardUid string) string {
+	return strings.TrimSuffix(o.GrafanaUrl, "/") + "/d/" + url.PathEscape(dashboardUid)
+}
+
+func (o DashboardLinkOptions) panelUrl(dashboardUid string, panelId int) string {
+	return o.dashboardUrl(dashboardUid) + "?viewPanel=" + strconv.Itoa(panelId)
+}
+
+// annotate adds `dashboard_url`, and `panel_url` if the alert has a panel
+func (o DashboardLinkOptions) annotate(annotations map[string]string, panelMetricName string) {
+	if o.DashboardUid == "" {
+		return
+	}
+
+	dashboardUid := o.DashboardUid
+	if uid, ok := o.PanelDashboardUids[panelMetricName]; ok {
+		dashboardUid = uid
+	}
+
+	annotations["dashboard_url"] = o.dashboardUrl(dashboardUid)
+
+	if panelId, ok := o.PanelIds[panelMetricName]; ok && panelMetricName != "" {
+		annotations["panel_url"] = o.panelUrl(dashboardUid, panelId)
+	}
+}
+
+// alertRuleUrl finds the alert in Grafana's alert list, whichever way the rules were deployed
+func (dg *DashboardGenerator) alertRuleUrl(alertName string) string {
+	return strings.TrimSuffix(dg.GrafanaUrl, "/") + "/alerting/list?search=" + url.QueryEscape(alertName)
+}
+
+// linkPanelsToAlerts gives each panel that shows an alerted metric a link to the alert rule, and a line at its threshold.
+// Bad thresholds are left for alert rule generation to report.
+func (dg *DashboardGenerator) linkPanelsToAlerts(dashboard *Dashboard) {
+	panelsById := make(map[int]*Panel)
+	for _, each := range dashboard.Panels {
+		panelsById[each.Id] = each
+		for _, nested := range each.Panels {
+			panelsById[nested.Id] = nested
+		}
+	}
+
+	displayPrefix := dg.displayPrefix(dg.currentMetricPrefix)
+
+	for _, each := range dg.alertRules {
+		panelId, ok := dg.panelIds[each.panelMetricName(dg.currentMetricPrefix)]
+		if !ok {
+			continue
+		}
+
+		alertName := ruleAlertName(displayPrefix, each)
+		panel := panelsById[panelId]
+		panel.Links = append(panel.Links, PanelLink{Title: "Alert: " + alertName, Url: dg.alertRuleUrl(alertName), TargetBlank: true})
+
+		if threshold, err := each.alertRuleThreshold(); err == nil {
+			if value, err := strconv.ParseFloat(threshold, 64); err == nil {
+				addThreshold(panel, value)
+			}
+		}
+	}
+}
+
+// addThreshold marks the value on the panel, once, in red
+func addThreshold(panel *Panel, value float64) {
+	if panel.GraphPanel != nil {
+		for _, each := range panel.Thresholds {
+			if each.(map[string]interface{})["value"] == value {
+				return
+			}
+		}
+		panel.Thresholds = append(panel.Thresholds, map[string]interface{}{"value": value, "op": "gt", "colorMode": "critical", "fill": false, "line": true})
+		return
+	}
+
+	if panel.FieldConfig == nil {
+		panel.FieldConfig = &FieldConfig{Overrides: []FieldOverride{}}
+	}
+	defaults := &panel.FieldConfig.Defaults
+	*defaults = withBaseThresholds(*defaults)
+
+	steps := defaults.Thresholds.Steps
+	for _, each := range steps {
+		if each.Value != nil && *each.Value == value {
+			return
+		}
+	}
+	steps = append(steps, ThresholdStep{Color: "red", Value: floatPtr(value)})
+	sort.SliceStable(steps, func(i, j int) bool {
+		return steps[i].Value == nil && steps[j].Value != nil || steps[i].Value != nil && steps[j].Value != nil && *steps[i].Value < *steps[j].Value
+	})
+	defaults.Thresholds.Steps = steps
+
+	if panel.Type == "timeseries" {
+		if defaults.Custom == nil {
+			defaults.Custom = make(map[string]interface{})
+		}
+		defaults.Custom["thresholdsStyle"] = map[string]string{"mode": "line"}
+	}
+}
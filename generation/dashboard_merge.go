@@ -0,0 +1,457 @@
+package generation
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// PanelOwnership marks a panel as generated, so that merging can tell it apart from panels added by hand
+type PanelOwnership struct {
+	Key  string `json:"key"`  // Stable across runs, as for the panel id
+	Hash string `json:"hash"` // Of the panel as generated, to spot hand edits
+}
+
+// Panel properties that Grafana users change without editing the panel itself, or that Grafana adds when saving it
+var unhashedPanelProperties = []string{"boulevard", "collapsed", "gridPos", "id", "panels", "pluginVersion"}
+
+type jsonObject = map[string]interface{}
+
+// DashboardMergeReport says what merging did to the existing dashboard
+type DashboardMergeReport struct {
+	Added     []string
+	Updated   []string
+	Removed   []string
+	Kept      []string // Hand-added, or hand-edited with nothing new generated
+	Conflicts []string // Hand-edited and changed by generation, where the hand edits were kept
+}
+
+func (r DashboardMergeReport) String() string {
+	return fmt.Sprintf("%d added, %d updated, %d removed, %d kept, %d conflicts", len(r.Added), len(r.Updated), len(r.Removed), len(r.Kept), len(r.Conflicts))
+}
+
+// stampPanels records the hash of each generated panel
+func stampPanels(panels []*Panel) error {
+	for _, each := range panels {
+		if each.Boulevard == nil {
+			continue
+		}
+
+		obj, err := toJsonObject(each)
+		if err != nil {
+			return err
+		}
+
+		each.Boulevard.Hash = panelHash(obj)
+
+		if err := stampPanels(each.Panels); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func panelHash(panel jsonObject) string {
+	hashed := make(jsonObject, len(panel))
+	for k, v := range panel {
+		hashed[k] = v
+	}
+	for _, each := range unhashedPanelProperties {
+		delete(hashed, each)
+	}
+
+	data, _ := json.Marshal(hashed) // Sorted keys, so the hash is stable
+	h := fnv.New64a()
+	_, _ = h.Write(data)
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// mergeDashboard updates the generated panels in the existing dashboard, keeping those added or edited by hand. Generated
+// panels the existing dashboard lacks are added at the bottom, and those no longer generated are removed. A generated
+// panel that was edited by hand is only updated if the edit was just to move or collapse it. Panels added by hand to a row
+// that is no longer generated are kept, below everything else.
+func mergeDashboard(existing []byte, generated []byte) ([]byte, DashboardMergeReport, error) {
+	var report DashboardMergeReport
+
+	var existingDashboard, generatedDashboard jsonObject
+	if err := json.Unmarshal(existing, &existingDashboard); err != nil {
+		return nil, report, fmt.Errorf("existing dashboard is not valid JSON: %v", err)
+	}
+	if err := json.Unmarshal(generated, &generatedDashboard); err != nil {
+		return nil, report, err
+	}
+
+	m := dashboardMerge{generated: make(map[string]jsonObject), parents: make(map[string]string), merged: make(map[string]jsonObject), report: &report}
+	m.index(objects(generatedDashboard["panels"]), "")
+
+	panels := m.mergePanels(objects(existingDashboard["panels"]))
+	panels = m.addOrphans(panels)
+	m.resolveOverlaps(panels)
+	panels = m.addNewPanels(panels)
+	assignMissingPanelIds(panels)
+
+	existingDashboard["panels"] = panels
+	mergeNamedList(existingDashboard, generatedDashboard, "templating")
+	mergeNamedList(existingDashboard, generatedDashboard, "annotations")
+
+	output, err := marshalIndented(existingDashboard)
+	return output, report, err
+}
+
+type dashboardMerge struct {
+	generated map[string]jsonObject // by key
+	order     []string              // of generated keys, with each row followed by the panels nested in it
+	parents   map[string]string     // key of the row each generated panel is nested in
+	merged    map[string]jsonObject // generated panels now in the merged dashboard, by key
+	orphans   []jsonObject          // hand-added panels from rows no longer generated
+	report    *DashboardMergeReport
+}
+
+func (m *dashboardMerge) index(panels []jsonObject, parentKey string) {
+	for _, each := range panels {
+		key, _ := ownership(each)
+		if key == "" {
+			continue
+		}
+
+		m.generated[key] = each
+		m.order = append(m.order, key)
+		m.parents[key] = parentKey
+		m.index(objects(each["panels"]), key)
+	}
+}
+
+func (m *dashboardMerge) mergePanels(panels []jsonObject) []interface{} {
+	merged := []interface{}{}
+
+	for _, each := range panels {
+		key, hash := ownership(each)
+		title := panelTitle(each)
+
+		if key == "" {
+			m.report.Kept = append(m.report.Kept, title)
+			if nested, ok := each["panels"]; ok {
+				each["panels"] = m.mergePanels(objects(nested))
+			}
+			merged = append(merged, each)
+			continue
+		}
+
+		generated, ok := m.generated[key]
+		if !ok {
+			m.report.Removed = append(m.report.Removed, title)
+			m.rescueNestedPanels(each)
+			continue
+		}
+
+		edited := panelHash(each) != hash
+		changed := panelHash(generated) != hash
+
+		panel := each
+		switch {
+		case !edited:
+			panel = copyObject(generated)
+			for _, property := range []string{"collapsed", "gridPos"} {
+				if value, ok := each[property]; ok {
+					panel[property] = value
+				}
+			}
+			m.report.Updated = append(m.report.Updated, title)
+		case changed:
+			m.report.Conflicts = append(m.report.Conflicts, fmt.Sprintf("panel %q was edited by hand, so was not updated. Delete it to regenerate it.", title))
+		default:
+			m.report.Kept = append(m.report.Kept, title)
+		}
+
+		if each["panels"] != nil || generated["panels"] != nil {
+			panel["panels"] = m.mergePanels(objects(each["panels"]))
+		}
+
+		m.merged[key] = panel
+		merged = append(merged, panel)
+	}
+
+	return merged
+}
+
+// rescueNestedPanels keeps the hand-added panels nested in a removed panel, for addOrphans to put at the top level
+func (m *dashboardMerge) rescueNestedPanels(removed jsonObject) {
+	for _, each := range objects(removed["panels"]) {
+		if key, _ := ownership(each); key != "" {
+			m.report.Removed = append(m.report.Removed, panelTitle(each))
+			m.rescueNestedPanels(each)
+			continue
+		}
+
+		m.report.Kept = append(m.report.Kept, panelTitle(each))
+		m.orphans = append(m.orphans, each)
+	}
+}
+
+// addOrphans puts the rescued panels below everything else, as laid out in their old row
+func (m *dashboardMerge) addOrphans(panels []interface{}) []interface{} {
+	if len(m.orphans) == 0 {
+		return panels
+	}
+
+	top := -1
+	for _, each := range m.orphans {
+		if y := gridPos(each).Y; top < 0 || y < top {
+			top = y
+		}
+	}
+
+	offset := panelsBottom(panels) - top
+	for _, each := range m.orphans {
+		shiftPanel(each, offset)
+		panels = append(panels, each)
+	}
+	return panels
+}
+
+// resolveOverlaps moves generated panels down, out of the way of panels added or moved by hand, which stay put
+func (m *dashboardMerge) resolveOverlaps(panels []interface{}) {
+	var placed []GridPos
+	var movable []jsonObject
+
+	for _, each := range objects(panels) {
+		key, _ := ownership(each)
+		if generated, ok := m.generated[key]; !ok || !samePosition(each, generated) {
+			placed = append(placed, gridPos(each))
+		} else {
+			movable = append(movable, each)
+		}
+	}
+
+	sort.SliceStable(movable, func(i, j int) bool {
+		a, b := gridPos(movable[i]), gridPos(movable[j])
+		return a.Y < b.Y || a.Y == b.Y && a.X < b.X
+	})
+
+	for _, each := range movable {
+		pos := gridPos(each)
+		for moved := true; moved; {
+			moved = false
+			for _, other := range placed {
+				if overlaps(pos, other) {
+					shiftPanel(each, other.Y+other.H-pos.Y)
+					pos = gridPos(each)
+					moved = true
+				}
+			}
+		}
+		placed = append(placed, pos)
+	}
+}
+
+func samePosition(a jsonObject, b jsonObject) bool {
+	return gridPos(a) == gridPos(b)
+}
+
+func panelsBottom(panels []interface{}) int {
+	result := 0
+	for _, each := range objects(panels) {
+		if pos, ok := each["gridPos"].(jsonObject); ok {
+			if y := number(pos["y"]) + number(pos["h"]); y > result {
+				result = y
+			}
+		}
+	}
+	return result
+}
+
+// assignMissingPanelIds gives an id to each panel added by hand without one, as Grafana would on saving
+func assignMissingPanelIds(panels []interface{}) {
+	used := make(map[int]bool)
+	var missing []jsonObject
+
+	var visit func(panels []jsonObject)
+	visit = func(panels []jsonObject) {
+		for _, each := range panels {
+			if id := number(each["id"]); id > 0 {
+				used[id] = true
+			} else {
+				missing = append(missing, each)
+			}
+			visit(objects(each["panels"]))
+		}
+	}
+	visit(objects(panels))
+
+	next := 1
+	for _, each := range missing {
+		for used[next] {
+			next++
+		}
+		each["id"] = next
+		used[next] = true
+	}
+}
+
+// handAddedPanelIds are the ids of panels added by hand to the existing dashboard, if any
+func handAddedPanelIds(existing []byte) []int {
+	if len(existing) == 0 {
+		return nil
+	}
+
+	var dashboard jsonObject
+	if err := json.Unmarshal(existing, &dashboard); err != nil {
+		return nil // Merging reports this
+	}
+
+	var ids []int
+	var visit func(panels []jsonObject)
+	visit = func(panels []jsonObject) {
+		for _, each := range panels {
+			if key, _ := ownership(each); key == "" {
+				if id := number(each["id"]); id > 0 {
+					ids = append(ids, id)
+				}
+			}
+			visit(objects(each["panels"]))
+		}
+	}
+	visit(objects(dashboard["panels"]))
+	return ids
+}
+
+// addNewPanels nests new panels in their row, if it's collapsed, otherwise puts them below everything else
+func (m *dashboardMerge) addNewPanels(panels []interface{}) []interface{} {
+	bottom := panelsBottom(panels)
+
+	offset, shifting := 0, false
+
+	for _, key := range m.order {
+		if _, ok := m.merged[key]; ok {
+			continue
+		}
+
+		panel := m.generated[key]
+		m.report.Added = append(m.report.Added, panelTitle(panel))
+		m.merged[key] = panel
+
+		for _, nested := range objects(panel["panels"]) {
+			nestedKey, _ := ownership(nested)
+			m.merged[nestedKey] = nested
+			m.report.Added = append(m.report.Added, panelTitle(nested))
+		}
+
+		if parent, ok := m.merged[m.parents[key]]; ok && parent["collapsed"] == true {
+			nested, _ := parent["panels"].([]interface{})
+			parent["panels"] = append(nested, panel)
+			continue
+		}
+
+		pos, _ := panel["gridPos"].(jsonObject)
+		if !shifting {
+			offset, shifting = bottom-number(pos["y"]), true
+		}
+
+		shiftPanel(panel, offset)
+		panels = append(panels, panel)
+	}
+
+	return panels
+}
+
+func shiftPanel(panel jsonObject, offset int) {
+	if pos, ok := panel["gridPos"].(jsonObject); ok {
+		pos["y"] = number(pos["y"]) + offset
+	}
+	for _, each := range objects(panel["panels"]) {
+		shiftPanel(each, offset)
+	}
+}
+
+// mergeNamedList replaces existing entries, e.g. of `templating.list`, with generated ones of the same name and adds
+// the rest, keeping any others
+func mergeNamedList(existing jsonObject, generated jsonObject, property string) {
+	generatedParent, _ := generated[property].(jsonObject)
+	if generatedParent == nil {
+		return
+	}
+
+	existingParent, _ := existing[property].(jsonObject)
+	if existingParent == nil {
+		existing[property] = generatedParent
+		return
+	}
+
+	generatedEntries := objects(generatedParent["list"])
+	byName := make(map[string]jsonObject)
+	for _, each := range generatedEntries {
+		byName[fmt.Sprint(each["name"])] = each
+	}
+
+	merged := []interface{}{}
+	for _, each := range objects(existingParent["list"]) {
+		name := fmt.Sprint(each["name"])
+		if replacement, ok := byName[name]; ok {
+			merged = append(merged, replacement)
+			delete(byName, name)
+		} else {
+			merged = append(merged, each)
+		}
+	}
+
+	for _, each := range generatedEntries {
+		if _, ok := byName[fmt.Sprint(each["name"])]; ok {
+			merged = append(merged, each)
+		}
+	}
+
+	existingParent["list"] = merged
+}
+
+func ownership(panel jsonObject) (string, string) {
+	owner, _ := panel["boulevard"].(jsonObject)
+	key, _ := owner["key"].(string)
+	hash, _ := owner["hash"].(string)
+	return key, hash
+}
+
+func panelTitle(panel jsonObject) string {
+	title, _ := panel["title"].(string)
+	return title
+}
+
+func objects(value interface{}) []jsonObject {
+	var result []jsonObject
+	list, _ := value.([]interface{})
+	for _, each := range list {
+		if obj, ok := each.(jsonObject); ok {
+			result = append(result, obj)
+		}
+	}
+	return result
+}
+
+func copyObject(obj jsonObject) jsonObject {
+	result := make(jsonObject, len(obj))
+	for k, v := range obj {
+		result[k] = v
+	}
+	return result
+}
+
+func number(value interface{}) int {
+	switch v := value.(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
+
+func toJsonObject(value interface{}) (jsonObject, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var obj jsonObject
+	err = json.Unmarshal(data, &obj)
+	return obj, err
+}
@@ -0,0 +1,128 @@
+package generation
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	RunbookLink          = "runbook"
+	RepoLink             = "repo"
+	RelatedDashboardLink = "dashboard" // By URL, or all the dashboards with given tags
+	PlainLink            = "link"
+)
+
+var refreshInterval = regexp.MustCompile(`^[1-9][0-9]*(ms|s|m|h|d|w|M|y)$`)
+
+// DashboardMetadataOptions are the dashboard-wide settings, which otherwise have Grafana's usual defaults
+type DashboardMetadataOptions struct {
+	Description string
+	TimeFrom    string // Defaults to now/d
+	TimeTo      string // Defaults to now
+	Refresh     string // Auto-refresh interval, e.g. 30s. Off by default.
+	Timezone    string // browser, utc or an IANA name. Defaults to the user's preference.
+	ReadOnly    bool
+	Links       []LinkOptions
+}
+
+// LinkOptions describes a link at the top of the dashboard, to a runbook, repository or related dashboards
+type LinkOptions struct {
+	Kind  string // runbook, repo, dashboard or link, the default
+	Title string // Defaults by kind
+	Url   string
+	Tags  []string // For a dashboard link, to list the dashboards with these tags instead of linking to a URL
+}
+
+// ParseDashboardLink reads a link given as `kind=url`, or as `dashboard=tag` for the dashboards with that tag
+func ParseDashboardLink(value string) (LinkOptions, error) {
+	kind, target, ok := strings.Cut(value, "=")
+	if !ok || target == "" {
+		return LinkOptions{}, fmt.Errorf("bad dashboard link %q, expected kind=url", value)
+	}
+
+	if kind == RelatedDashboardLink && !strings.Contains(target, "/") {
+		return LinkOptions{Kind: kind, Tags: []string{target}}, nil
+	}
+	return LinkOptions{Kind: kind, Url: target}, nil
+}
+
+func (o LinkOptions) link() (DashboardLink, error) {
+	if o.Url == "" && (o.Kind != RelatedDashboardLink || len(o.Tags) == 0) {
+		return DashboardLink{}, fmt.Errorf("dashboard link %q needs a url", stringOrDefault(o.Title, o.Kind))
+	}
+
+	link := DashboardLink{Title: o.Title, Type: "link", Url: o.Url, Tags: []string{}, TargetBlank: true}
+
+	switch o.Kind {
+	case RunbookLink:
+		link.Icon = "doc"
+		link.Title = stringOrDefault(o.Title, "Runbook")
+	case RepoLink:
+		link.Icon = "external link"
+		link.Title = stringOrDefault(o.Title, "Repository")
+	case RelatedDashboardLink:
+		link.IncludeVars = true
+		link.KeepTime = true
+		link.TargetBlank = false
+
+		if o.Url == "" {
+			link.Type = "dashboards"
+			link.Tags = o.Tags
+			link.AsDropdown = true
+			link.Title = stringOrDefault(o.Title, "Related dashboards")
+		} else {
+			link.Icon = "dashboard"
+			link.Title = stringOrDefault(o.Title, "Related dashboard")
+		}
+	case PlainLink, "":
+		link.Icon = "external link"
+		link.Title = stringOrDefault(o.Title, o.Url)
+	default:
+		return DashboardLink{}, fmt.Errorf("unsupported dashboard link kind %q, expected one of %s, %s, %s, %s", o.Kind, RunbookLink, RepoLink, RelatedDashboardLink, PlainLink)
+	}
+
+	return link, nil
+}
+
+// applyMetadata sets the configured description, time range, refresh, timezone, editability and links
+func (dg *DashboardGenerator) applyMetadata(dashboard *Dashboard) error {
+	opts := dg.Metadata
+
+	if opts.Refresh != "" && !refreshInterval.MatchString(opts.Refresh) {
+		return fmt.Errorf("bad dashboard refresh %q, expected an interval like 30s or 5m", opts.Refresh)
+	}
+
+	if opts.Timezone != "" && opts.Timezone != "browser" && opts.Timezone != "utc" {
+		if _, err := time.LoadLocation(opts.Timezone); err != nil {
+			return fmt.Errorf("bad dashboard timezone %q, expected browser, utc or an IANA name", opts.Timezone)
+		}
+	}
+
+	dashboard.Description = opts.Description
+	dashboard.Time = TimeRange{From: stringOrDefault(opts.TimeFrom, dashboard.Time.From), To: stringOrDefault(opts.TimeTo, dashboard.Time.To)}
+	dashboard.Timezone = opts.Timezone
+	dashboard.Editable = !opts.ReadOnly
+
+	if opts.Refresh != "" {
+		dashboard.Refresh = opts.Refresh
+	}
+
+	for _, each := range opts.Links {
+		link, err := each.link()
+		if err != nil {
+			return err
+		}
+		dashboard.Links = append(dashboard.Links, link)
+	}
+
+	return nil
+}
+
+func stringOrDefault(value string, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,275 @@
+package generation
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// buildDashboard creates the panels for each metric, in discovery order, followed by those for external timers, then lays them out
+func (dg *DashboardGenerator) buildDashboard(data *dashboardData) (*Dashboard, error) {
+	dg.panelIds = make(map[string]int)
+	dg.usedPanelIds = make(map[int]bool)
+
+	// Steer clear of panels added by hand to the dashboard being merged into
+	for _, each := range handAddedPanelIds(data.Existing) {
+		dg.usedPanelIds[each] = true
+	}
+
+	dashboard := newDashboard(data.Title, data.Id, append([]string{}, data.DashboardTags...), dg.schemaVersion())
+	if err := dg.applyMetadata(dashboard); err != nil {
+		return nil, err
+	}
+	dashboard.Templating.List = dg.templateVariables(data.Metrics)
+
+	var entries []layoutEntry
+
+	if data.REDSource != nil {
+		red, err := dg.redPanels(data.REDSource)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, red...)
+	}
+
+	linkTemplates, err := dg.dataLinkTemplates()
+	if err != nil {
+		return nil, err
+	}
+
+	shown := make(map[*metric]bool)
+	var linkErr error
+
+	addEntry := func(m *metric, external bool, key string, panel *Panel, metricNames ...string) {
+		if data.Overview && shown[m] && m.MetricType != "errors" {
+			return
+		}
+		shown[m] = true
+		if err := dg.addDataLinks(linkTemplates, panel, m); err != nil && linkErr == nil {
+			linkErr = err
+		}
+		entries = append(entries, layoutEntry{panel: panel, key: key, row: dg.rowFor(m, external), metricNames: metricNames})
+	}
+
+	alreadyGotError := false
+
+	for _, each := range data.Metrics {
+		switch each.MetricType {
+		case "counter":
+			addEntry(each, false, each.panelKey("cumulative"), dg.counterCumulativePanel(each), each.FullMetricName)
+			addEntry(each, false, each.panelKey("rate"), dg.counterRatePanel(each), each.FullMetricName)
+		case "gauge":
+			addEntry(each, false, each.panelKey("current"), dg.gaugeCurrentPanel(each), each.FullMetricName)
+			addEntry(each, false, each.panelKey("overtime"), dg.gaugeOverTimePanel(each), each.FullMetricName)
+			if len(each.LabelNames) > 0 {
+				addEntry(each, false, each.panelKey("breakdown"), dg.gaugeBreakdownPanel(each), each.FullMetricName)
+			}
+		case "errors":
+			if !alreadyGotError {
+				for _, entry := range dg.errorPanels(each) {
+					addEntry(each, false, entry.key, entry.panel, entry.metricNames...)
+				}
+				alreadyGotError = true
+			}
+		case "summary", "timer":
+			addEntry(each, false, each.panelKey("quantiles"), dg.summaryTimerPanel(each), each.FullMetricName)
+		case "histogram":
+			if !dg.legacyPanels() {
+				addEntry(each, false, each.panelKey("heatmap"), dg.histogramPanel(each), each.FullMetricName)
+			}
+		}
+	}
+
+	for _, each := range data.ExternalMetrics {
+		if each.externalPanel == "heatmap" && dg.legacyPanels() {
+			continue
+		}
+		addEntry(each, true, each.panelKey(each.externalPanel), externalPanelKinds[each.externalPanel](dg, each), each.series())
+	}
+
+	if linkErr != nil {
+		return nil, linkErr
+	}
+
+	if data.Runtime {
+		entries = append(entries, dg.runtimePanels()...)
+	}
+
+	if err := dg.layoutPanels(dashboard, entries); err != nil {
+		return nil, err
+	}
+
+	dashboard.Annotations.List = append(dashboard.Annotations.List, dg.alertAnnotations()...)
+	dg.linkPanelsToAlerts(dashboard)
+
+	if err := stampPanels(dashboard.Panels); err != nil {
+		return nil, err
+	}
+
+	return dashboard, nil
+}
+
+// addPanel assigns the panel its id, recording it as the panel for the given metrics if they have none yet
+func (dg *DashboardGenerator) addPanel(dashboard *Dashboard, panel *Panel, key string, metricNames ...string) {
+	dg.assignPanelId(panel, key, metricNames)
+	dashboard.Panels = append(dashboard.Panels, panel)
+}
+
+// nestPanel places the panel inside a collapsed row
+func (dg *DashboardGenerator) nestPanel(row *Panel, panel *Panel, key string, metricNames ...string) {
+	dg.assignPanelId(panel, key, metricNames)
+	row.Panels = append(row.Panels, panel)
+}
+
+// assignPanelId derives the id from the panel's key, so that it stays the same as other metrics come and go. In the
+// unlikely event of a clash, the next free id is used instead.
+func (dg *DashboardGenerator) assignPanelId(panel *Panel, key string, metricNames []string) {
+	panel.Id = freePanelId(key, dg.usedPanelIds)
+	panel.Boulevard = &PanelOwnership{Key: key}
+
+	for _, each := range metricNames {
+		if _, ok := dg.panelIds[each]; !ok {
+			dg.panelIds[each] = panel.Id
+		}
+	}
+}
+
+const maxPanelId = 1<<31 - 1
+
+func freePanelId(key string, used map[int]bool) int {
+	id := panelIdForKey(key)
+	for used[id] {
+		id = id%maxPanelId + 1
+	}
+
+	used[id] = true
+	return id
+}
+
+func panelIdForKey(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32()%maxPanelId) + 1
+}
+
+func (dg *DashboardGenerator) schemaVersion() int {
+	if dg.SchemaVersion > 0 {
+		return dg.SchemaVersion
+	}
+	return DefaultSchemaVersion
+}
+
+func (dg *DashboardGenerator) legacyPanels() bool {
+	return dg.schemaVersion() < firstTimeseriesSchemaVersion
+}
+
+// datasource refers to the datasource variable, set to DatasourceUid by default
+func (dg *DashboardGenerator) datasource() DataSourceRef {
+	return DataSourceRef{Type: "prometheus", Uid: "${" + datasourceVariable + "}"}
+}
+
+func (dg *DashboardGenerator) datasourceUid() string {
+	if dg.DatasourceUid != "" {
+		return dg.DatasourceUid
+	}
+	return DefaultGrafanaDatasourceUid
+}
+
+// Counts over the dashboard's time range: a single stat, or a bar per label combination. Using `increase` rather than
+// the raw counter means process restarts don't show as drops.
+func (dg *DashboardGenerator) counterCumulativePanel(m *metric) *Panel {
+	expr := fmt.Sprintf("sum(increase(%s%s[$__range]))%s", m.FullMetricName, dg.metricSelector(m), m.MetricLabels)
+	title := m.PanelTitle + " (cumulative)"
+
+	if dg.legacyPanels() {
+		return newGraphPanel(legacyDatasource, title, dg.unit(m), Target{Expr: expr, IntervalFactor: 1, RefId: "A"})
+	}
+
+	target := Target{Expr: expr, Instant: true, LegendFormat: legendFormat(m.LabelNames), RefId: "A"}
+
+	if len(m.LabelNames) > 0 {
+		return newBarGaugePanel(dg.datasource(), title, FieldDefaults{Unit: dg.unit(m)}, target)
+	}
+	return newStatPanel(dg.datasource(), title, FieldDefaults{Unit: dg.unit(m)}, target)
+}
+
+func (dg *DashboardGenerator) counterRatePanel(m *metric) *Panel {
+	expr := fmt.Sprintf("sum(rate(%s%s[15m]))%s", m.FullMetricName, dg.metricSelector(m), m.MetricLabels)
+	title := m.PanelTitle + " (per second)"
+
+	if dg.legacyPanels() {
+		return newGraphPanel(legacyDatasource, title, dg.rateUnit(m), Target{Expr: expr, IntervalFactor: 1, RefId: "A"})
+	}
+	return withAxisLabel(newTimeseriesPanel(dg.datasource(), title, FieldDefaults{Unit: dg.rateUnit(m)}, Target{Expr: expr, LegendFormat: legendFormat(m.LabelNames), RefId: "A"}), "per second")
+}
+
+// The latest value, over all label values
+func (dg *DashboardGenerator) gaugeCurrentPanel(m *metric) *Panel {
+	expr := fmt.Sprintf("sum(%s%s)", m.FullMetricName, dg.metricSelector(m))
+	title := m.PanelTitle + " (current)"
+
+	if dg.legacyPanels() {
+		return newGraphPanel(legacyDatasource, title, dg.unit(m), Target{Expr: expr, IntervalFactor: 1, RefId: "A"})
+	}
+	return newGaugePanel(dg.datasource(), title, FieldDefaults{Unit: dg.unit(m)}, Target{Expr: expr, Instant: true, RefId: "A"})
+}
+
+// The value over time, with its min, max and average over the dashboard's time range
+func (dg *DashboardGenerator) gaugeOverTimePanel(m *metric) *Panel {
+	expr := fmt.Sprintf("sum(%s%s)", m.FullMetricName, dg.metricSelector(m))
+	title := m.PanelTitle + " (over time)"
+
+	if dg.legacyPanels() {
+		panel := newGraphPanel(legacyDatasource, title, dg.unit(m), Target{Expr: expr, IntervalFactor: 1, RefId: "A"})
+		panel.Legend = GraphLegend{Avg: true, Max: true, Min: true, Show: true, Values: true}
+		return panel
+	}
+
+	panel := newTimeseriesPanel(dg.datasource(), title, FieldDefaults{Unit: dg.unit(m)}, Target{Expr: expr, LegendFormat: m.PanelTitle, RefId: "A"})
+	panel.Options.(map[string]interface{})["legend"] = map[string]interface{}{"displayMode": "table", "placement": "bottom", "showLegend": true, "calcs": []string{"min", "max", "mean"}}
+	return panel
+}
+
+// The latest value for each label combination
+func (dg *DashboardGenerator) gaugeBreakdownPanel(m *metric) *Panel {
+	expr := fmt.Sprintf("sum(%s%s)%s", m.FullMetricName, dg.metricSelector(m), m.MetricLabels)
+	title := m.PanelTitle + " (by " + strings.Join(m.LabelNames, ", ") + ")"
+
+	if dg.legacyPanels() {
+		return newGraphPanel(legacyDatasource, title, dg.unit(m), Target{Expr: expr, IntervalFactor: 1, LegendFormat: legendFormat(m.LabelNames), RefId: "A"})
+	}
+	return newBarGaugePanel(dg.datasource(), title, FieldDefaults{Unit: dg.unit(m)}, Target{Expr: expr, Instant: true, LegendFormat: legendFormat(m.LabelNames), RefId: "A"})
+}
+
+func (dg *DashboardGenerator) summaryTimerPanel(m *metric) *Panel {
+	expr := fmt.Sprintf(`avg(%s%s)%s`, m.FullMetricName, dg.metricSelector(m, `quantile=~"0.5|0.75|0.9|0.99"`), m.MetricLabels)
+
+	if dg.legacyPanels() {
+		return newGraphPanel(legacyDatasource, m.PanelTitle, dg.unit(m), Target{Expr: expr, Format: "time_series", IntervalFactor: 1, RefId: "A"})
+	}
+
+	target := Target{Expr: expr, Format: "time_series", LegendFormat: legendFormat(append(append([]string{}, m.LabelNames...), "quantile")), RefId: "A"}
+	return newTimeseriesPanel(dg.datasource(), m.PanelTitle, FieldDefaults{Unit: dg.unit(m), Min: floatPtr(0)}, target)
+}
+
+func (dg *DashboardGenerator) histogramPanel(m *metric) *Panel {
+	expr := fmt.Sprintf("sum(rate(%s_bucket%s[$__rate_interval])) by (le)", m.FullMetricName, dg.metricSelector(m))
+	return newHeatmapPanel(dg.datasource(), m.PanelTitle, dg.unit(m), Target{Expr: expr, Format: "heatmap", LegendFormat: "{{le}}", RefId: "A"})
+}
+
+func withAxisLabel(panel *Panel, label string) *Panel {
+	panel.FieldConfig.Defaults.Custom["axisLabel"] = label
+	return panel
+}
+
+func legendFormat(labelNames []string) string {
+	formats := make([]string, len(labelNames))
+	for i, each := range labelNames {
+		formats[i] = "{{" + each + "}}"
+	}
+	return strings.Join(formats, ", ")
+}
+
+func floatPtr(value float64) *float64 {
+	return &value
+}
@@ -0,0 +1,273 @@
+package generation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// GrafanaClient talks to the Grafana HTTP API, as a service account
+type GrafanaClient struct {
+	Address     string
+	BearerToken string // Service account token
+	HttpClient  *http.Client
+}
+
+// DashboardPushOptions says where and how dashboards are saved
+type DashboardPushOptions struct {
+	Folder    string // Title of the folder, created if need be. Blank for the General folder.
+	Overwrite bool   // Replace the dashboard even if it was changed in Grafana while pushing, or has the title of another
+	Message   string // Shown in the dashboard's version history
+}
+
+const (
+	DashboardCreated   = "create"
+	DashboardUpdated   = "update"
+	DashboardUnchanged = "unchanged"
+)
+
+type DashboardPush struct {
+	Action  string
+	Uid     string
+	Title   string
+	Version int // The version now in Grafana
+	Url     string
+}
+
+func (p DashboardPush) String() string {
+	return fmt.Sprintf("%s %s (%s), version %d", p.Action, p.Uid, p.Title, p.Version)
+}
+
+type grafanaFolder struct {
+	Uid   string `json:"uid"`
+	Title string `json:"title"`
+}
+
+type grafanaDashboardResponse struct {
+	Dashboard jsonObject `json:"dashboard"`
+	Meta      struct {
+		FolderUid string `json:"folderUid"`
+		Url       string `json:"url"`
+	} `json:"meta"`
+}
+
+type grafanaSaveRequest struct {
+	Dashboard jsonObject `json:"dashboard"`
+	FolderUid string     `json:"folderUid,omitempty"`
+	Message   string     `json:"message,omitempty"`
+	Overwrite bool       `json:"overwrite"`
+}
+
+type grafanaSaveResponse struct {
+	Uid     string `json:"uid"`
+	Url     string `json:"url"`
+	Version int    `json:"version"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// ReadDashboardFile reads a generated dashboard, from within the resource it was written as, if any
+func ReadDashboardFile(filePath string, resource DashboardResourceOptions) (jsonObject, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if data, err = resource.unwrap(data); err != nil {
+		return nil, fmt.Errorf("bad dashboard file %s: %v", filePath, err)
+	}
+
+	var dashboard jsonObject
+	if err := json.Unmarshal(data, &dashboard); err != nil {
+		return nil, fmt.Errorf("bad dashboard file %s: %v", filePath, err)
+	}
+
+	if uid, _ := dashboard["uid"].(string); uid == "" {
+		return nil, fmt.Errorf("no uid in dashboard file %s", filePath)
+	}
+
+	return dashboard, nil
+}
+
+// FindOrCreateFolder returns the UID of the top-level folder with the title
+func (c *GrafanaClient) FindOrCreateFolder(title string) (string, error) {
+	body, status, err := c.do(http.MethodGet, "/api/folders?limit=1000", nil)
+	if err != nil {
+		return "", err
+	}
+
+	if status != http.StatusOK {
+		return "", fmt.Errorf("Grafana folder list failed: %d %s", status, body)
+	}
+
+	var folders []grafanaFolder
+	if err := json.Unmarshal(body, &folders); err != nil {
+		return "", fmt.Errorf("bad Grafana folder list: %v", err)
+	}
+
+	for _, each := range folders {
+		if each.Title == title {
+			return each.Uid, nil
+		}
+	}
+
+	payload, _ := json.Marshal(grafanaFolder{Title: title})
+
+	body, status, err = c.do(http.MethodPost, "/api/folders", payload)
+	if err != nil {
+		return "", err
+	}
+
+	if status != http.StatusOK {
+		return "", fmt.Errorf("Grafana folder creation of %s failed: %d %s", title, status, body)
+	}
+
+	var created grafanaFolder
+	if err := json.Unmarshal(body, &created); err != nil {
+		return "", fmt.Errorf("bad Grafana folder: %v", err)
+	}
+
+	return created.Uid, nil
+}
+
+// getDashboard returns the saved dashboard, or nil if there isn't one with the UID
+func (c *GrafanaClient) getDashboard(uid string) (*grafanaDashboardResponse, error) {
+	body, status, err := c.do(http.MethodGet, "/api/dashboards/uid/"+url.PathEscape(uid), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if status == http.StatusNotFound {
+		return nil, nil
+	}
+
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("Grafana dashboard fetch of %s failed: %d %s", uid, status, body)
+	}
+
+	var existing grafanaDashboardResponse
+	if err := json.Unmarshal(body, &existing); err != nil {
+		return nil, fmt.Errorf("bad Grafana dashboard %s: %v", uid, err)
+	}
+
+	return &existing, nil
+}
+
+// PushDashboard saves the dashboard, unless Grafana already has it as-is. Without Overwrite, the save is against the version
+// just read, so Grafana refuses it if the dashboard has been changed there since.
+func (c *GrafanaClient) PushDashboard(dashboard jsonObject, opts DashboardPushOptions) (DashboardPush, error) {
+	uid, _ := dashboard["uid"].(string)
+	title, _ := dashboard["title"].(string)
+	push := DashboardPush{Action: DashboardCreated, Uid: uid, Title: title}
+
+	var folderUid string
+	if opts.Folder != "" {
+		var err error
+		if folderUid, err = c.FindOrCreateFolder(opts.Folder); err != nil {
+			return push, err
+		}
+	}
+
+	existing, err := c.getDashboard(uid)
+	if err != nil {
+		return push, err
+	}
+
+	saved := copyObject(dashboard)
+	delete(saved, "id")
+
+	if existing != nil {
+		push.Action = DashboardUpdated
+		push.Version = number(existing.Dashboard["version"])
+		push.Url = existing.Meta.Url
+
+		if existing.Meta.FolderUid == folderUid && sameDashboard(existing.Dashboard, saved) {
+			push.Action = DashboardUnchanged
+			return push, nil
+		}
+
+		saved["version"] = push.Version
+	} else {
+		delete(saved, "version")
+	}
+
+	payload, err := json.Marshal(grafanaSaveRequest{Dashboard: saved, FolderUid: folderUid, Message: opts.Message, Overwrite: opts.Overwrite})
+	if err != nil {
+		return push, err
+	}
+
+	body, status, err := c.do(http.MethodPost, "/api/dashboards/db", payload)
+	if err != nil {
+		return push, err
+	}
+
+	var response grafanaSaveResponse
+	_ = json.Unmarshal(body, &response)
+
+	if status == http.StatusPreconditionFailed {
+		return push, fmt.Errorf("Grafana refused dashboard %s (%s): %s. Overwrite to replace it", uid, response.Status, response.Message)
+	}
+
+	if status != http.StatusOK {
+		return push, fmt.Errorf("Grafana dashboard save of %s failed: %d %s", uid, status, body)
+	}
+
+	push.Version = response.Version
+	push.Url = response.Url
+	return push, nil
+}
+
+// sameDashboard ignores what Grafana assigns on saving
+func sameDashboard(existing jsonObject, dashboard jsonObject) bool {
+	normalised := func(d jsonObject) jsonObject {
+		result := make(jsonObject, len(d))
+		for k, v := range d {
+			if k != "id" && k != "version" {
+				result[k] = v
+			}
+		}
+
+		// Make numbers etc. comparable
+		data, _ := json.Marshal(result)
+		_ = json.Unmarshal(data, &result)
+		return result
+	}
+	return reflect.DeepEqual(normalised(existing), normalised(dashboard))
+}
+
+func (c *GrafanaClient) do(method string, path string, payload []byte) ([]byte, int, error) {
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.Address, "/")+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.BearerToken)
+	}
+
+	httpClient := c.HttpClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Grafana request failed: %v", err)
+	}
+
+	//goland:noinspection GoUnhandledErrorResult
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	return body, resp.StatusCode, err
+}
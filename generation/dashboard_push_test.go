@@ -0,0 +1,189 @@
+package generation
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/tools/go/packages"
+)
+
+// Minimal stand-in for the Grafana folder and dashboard APIs
+type fakeGrafana struct {
+	sync.Mutex
+	token      string
+	folders    []grafanaFolder
+	dashboards map[string]grafanaDashboardResponse
+	saves      []grafanaSaveRequest
+}
+
+func (f *fakeGrafana) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer "+f.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/folders":
+		_ = json.NewEncoder(w).Encode(f.folders)
+	case r.Method == http.MethodPost && r.URL.Path == "/api/folders":
+		var folder grafanaFolder
+		_ = json.NewDecoder(r.Body).Decode(&folder)
+		folder.Uid = kubernetesResourceName(folder.Title)
+		f.folders = append(f.folders, folder)
+		_ = json.NewEncoder(w).Encode(folder)
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/dashboards/uid/"):
+		if existing, ok := f.dashboards[strings.TrimPrefix(r.URL.Path, "/api/dashboards/uid/")]; ok {
+			_ = json.NewEncoder(w).Encode(existing)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case r.Method == http.MethodPost && r.URL.Path == "/api/dashboards/db":
+		var save grafanaSaveRequest
+		if err := json.NewDecoder(r.Body).Decode(&save); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		uid := save.Dashboard["uid"].(string)
+		existing, ok := f.dashboards[uid]
+		version := 1
+		if ok {
+			version = number(existing.Dashboard["version"]) + 1
+			if !save.Overwrite && number(save.Dashboard["version"]) != version-1 {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				_ = json.NewEncoder(w).Encode(grafanaSaveResponse{Status: "version-mismatch", Message: "The dashboard has been changed by someone else"})
+				return
+			}
+		}
+
+		recorded := save
+		recorded.Dashboard = copyObject(save.Dashboard)
+		f.saves = append(f.saves, recorded)
+
+		save.Dashboard["version"] = version
+		saved := grafanaDashboardResponse{Dashboard: save.Dashboard}
+		saved.Meta.FolderUid = save.FolderUid
+		saved.Meta.Url = "/d/" + uid
+		f.dashboards[uid] = saved
+		_ = json.NewEncoder(w).Encode(grafanaSaveResponse{Uid: uid, Url: saved.Meta.Url, Version: version, Status: "success"})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestDashboardPush(t *testing.T) {
+	loadedPkgs, err := packages.Load(&scanConf, "")
+	assert.NoError(t, err)
+
+	generator := &DashboardGenerator{}
+	metrics, _ := generator.DiscoverMetrics(loadedPkgs)
+
+	dashboardPath := filepath.Join(t.TempDir(), "grafana_dashboard.json")
+	assert.NoError(t, generator.GenerateGrafanaDashboard(dashboardPath, metrics, nil, nil))
+
+	dashboard, err := ReadDashboardFile(dashboardPath, generator.DashboardResource)
+	assert.NoError(t, err)
+
+	grafana := &fakeGrafana{token: "secret", folders: []grafanaFolder{{Uid: "other", Title: "Other"}}, dashboards: make(map[string]grafanaDashboardResponse)}
+	server := httptest.NewServer(grafana)
+	defer server.Close()
+
+	client := &GrafanaClient{Address: server.URL + "/", BearerToken: "secret"}
+	opts := DashboardPushOptions{Folder: "Payments", Message: "Release 1.2.3"}
+
+	push, err := client.PushDashboard(dashboard, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, DashboardPush{Action: DashboardCreated, Uid: "prefix_generated", Title: "prefix Visualised Metrics", Version: 1, Url: "/d/prefix_generated"}, push)
+	assert.Equal(t, []grafanaFolder{{Uid: "other", Title: "Other"}, {Uid: "payments", Title: "Payments"}}, grafana.folders)
+	assert.Equal(t, "payments", grafana.saves[0].FolderUid)
+	assert.Equal(t, "Release 1.2.3", grafana.saves[0].Message)
+	assert.NotContains(t, grafana.saves[0].Dashboard, "id")
+	assert.NotContains(t, grafana.saves[0].Dashboard, "version")
+
+	// Nothing to do
+	push, err = client.PushDashboard(dashboard, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, DashboardUnchanged, push.Action)
+	assert.Equal(t, 1, push.Version)
+	assert.Len(t, grafana.saves, 1)
+	assert.Len(t, grafana.folders, 2)
+
+	dashboard["description"] = "Changed"
+	push, err = client.PushDashboard(dashboard, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, DashboardUpdated, push.Action)
+	assert.Equal(t, 2, push.Version)
+	assert.Equal(t, float64(1), grafana.saves[1].Dashboard["version"])
+
+	// Moving folder is a change too
+	push, err = client.PushDashboard(dashboard, DashboardPushOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, DashboardUpdated, push.Action)
+	assert.Equal(t, "", grafana.saves[2].FolderUid)
+
+	// Still nothing to do, though Grafana's version is now ahead of the file's
+	push, err = client.PushDashboard(dashboard, DashboardPushOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, DashboardPush{Action: DashboardUnchanged, Uid: "prefix_generated", Title: "prefix Visualised Metrics", Version: 3, Url: "/d/prefix_generated"}, push)
+	assert.Len(t, grafana.saves, 3)
+
+	// Changed in Grafana while pushing
+	dashboard["description"] = "Changed again"
+	racingClient := &GrafanaClient{Address: server.URL, BearerToken: "secret", HttpClient: &http.Client{Transport: &racingTransport{grafana: grafana}}}
+
+	_, err = racingClient.PushDashboard(dashboard, DashboardPushOptions{})
+	assert.EqualError(t, err, "Grafana refused dashboard prefix_generated (version-mismatch): The dashboard has been changed by someone else. Overwrite to replace it")
+
+	push, err = racingClient.PushDashboard(dashboard, DashboardPushOptions{Overwrite: true})
+	assert.NoError(t, err)
+	assert.Equal(t, DashboardUpdated, push.Action)
+	assert.True(t, grafana.saves[3].Overwrite)
+
+	_, err = (&GrafanaClient{Address: server.URL, BearerToken: "wrong"}).PushDashboard(dashboard, opts)
+	assert.EqualError(t, err, "Grafana folder list failed: 401 ")
+
+	_ = os.WriteFile(dashboardPath, []byte(`{"title": "No UID"}`), 0644)
+	_, err = ReadDashboardFile(dashboardPath, DashboardResourceOptions{})
+	assert.EqualError(t, err, "no uid in dashboard file "+dashboardPath)
+
+	// Written as a Helm-templated ConfigMap, as for a chart
+	generator.DashboardResource = DashboardResourceOptions{Format: ConfigMapFormat, HelmTemplate: true}
+	resourcePath := filepath.Join(t.TempDir(), "grafana-dashboard.yaml")
+	assert.NoError(t, generator.GenerateGrafanaDashboard(resourcePath, metrics, nil, nil))
+
+	dashboard, err = ReadDashboardFile(resourcePath, generator.DashboardResource)
+	assert.NoError(t, err)
+	assert.Equal(t, "prefix_generated", dashboard["uid"])
+
+	_, err = ReadDashboardFile(resourcePath, DashboardResourceOptions{})
+	assert.ErrorContains(t, err, "bad dashboard file "+resourcePath)
+}
+
+// racingTransport bumps the version in Grafana after each dashboard is read, as if edited there
+type racingTransport struct {
+	grafana *fakeGrafana
+}
+
+func (t *racingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(r)
+
+	if err == nil && r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/dashboards/uid/") {
+		t.grafana.Lock()
+		for _, each := range t.grafana.dashboards {
+			each.Dashboard["version"] = number(each.Dashboard["version"]) + 1
+		}
+		t.grafana.Unlock()
+	}
+
+	return resp, err
+}
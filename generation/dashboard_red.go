@@ -0,0 +1,124 @@
+package generation
+
+import (
+	"fmt"
+	"strings"
+)
+
+const redRow = "RED"
+
+// redMetrics are the metrics named by a REDMetrics annotation, e.g. `(requests = handled, errors = failed, duration = handler)`
+type redMetrics struct {
+	requests string
+	errors   string
+	duration string
+}
+
+// redPanels show the request rate, error ratio and p99 latency. Metrics not named by annotation are picked by
+// convention: the first latency timer or histogram for duration, a requests counter or else the duration's count for
+// requests, and all the errors.
+func (dg *DashboardGenerator) redPanels(metrics []*metric) ([]layoutEntry, error) {
+	var named redMetrics
+	if dg.redMetrics != nil {
+		named = *dg.redMetrics
+	}
+
+	duration, err := findMetric(metrics, named.duration, func(m *metric) bool {
+		return (m.MetricType == "timer" || m.MetricType == "histogram" || m.MetricType == "summary") && dg.unit(m) == "s"
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	requests, err := findMetric(metrics, named.requests, func(m *metric) bool {
+		return m.MetricType == "counter" && strings.Contains(strings.ToLower(m.FullMetricName), "request")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	errors, err := findMetric(metrics, named.errors, func(m *metric) bool { return m.MetricType == "errors" })
+	if err != nil {
+		return nil, err
+	}
+
+	requestRate := dg.requestRateExpr(requests, duration)
+	if requestRate == "" {
+		return nil, nil
+	}
+
+	entries := []layoutEntry{{panel: dg.redStatPanel("Request rate", "reqps", requestRate, nil), key: "red/requests"}}
+
+	if errorRate := dg.errorRateExpr(errors, named.errors != ""); errorRate != "" {
+		thresholds := &Thresholds{Mode: "absolute", Steps: []ThresholdStep{{Color: "green"}, {Color: "orange", Value: floatPtr(0.01)}, {Color: "red", Value: floatPtr(0.05)}}}
+		entries = append(entries, layoutEntry{panel: dg.redStatPanel("Error ratio", "percentunit", fmt.Sprintf("(%s) / (%s)", errorRate, requestRate), thresholds), key: "red/errors"})
+	}
+
+	if duration != nil {
+		entries = append(entries, layoutEntry{panel: dg.redStatPanel("p99 latency", dg.unit(duration), dg.p99Expr(duration), nil), key: "red/duration"})
+	}
+
+	for i := range entries {
+		entries[i].row = redRow
+	}
+	return entries, nil
+}
+
+// findMetric looks up the named metric, or else the first that matches the convention
+func findMetric(metrics []*metric, name string, convention func(m *metric) bool) (*metric, error) {
+	for _, each := range metrics {
+		if name == "" && convention(each) || name != "" && (name == each.PanelTitle || name == each.normalisedMetricName || name == each.FullMetricName) {
+			return each, nil
+		}
+	}
+
+	if name != "" {
+		return nil, fmt.Errorf("no metric %s for the RED row", name)
+	}
+	return nil, nil
+}
+
+func (dg *DashboardGenerator) requestRateExpr(requests *metric, duration *metric) string {
+	switch {
+	case requests != nil && requests.MetricType == "counter":
+		return fmt.Sprintf("sum(rate(%s%s[$__rate_interval]))", requests.FullMetricName, dg.metricSelector(requests))
+	case requests != nil:
+		return fmt.Sprintf("sum(rate(%s_count%s[$__rate_interval]))", requests.FullMetricName, dg.metricSelector(requests))
+	case duration != nil:
+		return fmt.Sprintf("sum(rate(%s_count%s[$__rate_interval]))", duration.FullMetricName, dg.metricSelector(duration))
+	}
+	return ""
+}
+
+// errorRateExpr counts all errors, or just the named error type, or the named counter
+func (dg *DashboardGenerator) errorRateExpr(errors *metric, named bool) string {
+	if errors == nil {
+		return ""
+	}
+
+	if errors.MetricType != "errors" {
+		return fmt.Sprintf("sum(rate(%s%s[$__rate_interval]))", errors.FullMetricName, dg.metricSelector(errors))
+	}
+
+	all := &metric{MetricsPrefix: errors.MetricsPrefix, FullMetricName: errors.MetricsPrefix + "errors"}
+
+	var matchers []string
+	if named {
+		matchers = append(matchers, fmt.Sprintf(`error_type="%s"`, errors.PanelTitle))
+	}
+	return fmt.Sprintf("sum(rate(%s%s[$__rate_interval]))", all.FullMetricName, dg.metricSelector(all, matchers...))
+}
+
+func (dg *DashboardGenerator) p99Expr(duration *metric) string {
+	if duration.MetricType == "histogram" {
+		return fmt.Sprintf("histogram_quantile(0.99, sum(rate(%s_bucket%s[$__rate_interval])) by (le))", duration.FullMetricName, dg.metricSelector(duration))
+	}
+	return fmt.Sprintf("max(%s%s)", duration.FullMetricName, dg.metricSelector(duration, `quantile="0.99"`))
+}
+
+func (dg *DashboardGenerator) redStatPanel(title string, unit string, expr string, thresholds *Thresholds) *Panel {
+	if dg.legacyPanels() {
+		return newGraphPanel(legacyDatasource, title, unit, Target{Expr: expr, IntervalFactor: 1, RefId: "A"})
+	}
+	return newStatPanel(dg.datasource(), title, FieldDefaults{Unit: unit, Thresholds: thresholds}, Target{Expr: expr, RefId: "A"})
+}
@@ -0,0 +1,181 @@
+package generation
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	DashboardJsonFormat     = "json"
+	ConfigMapFormat         = "configmap"        // For the Grafana sidecar, which watches ConfigMaps with its label
+	GrafanaDashboardFormat  = "grafanadashboard" // GrafanaDashboard custom resource for grafana-operator
+	DefaultFolderAnnotation = "grafana_folder"
+)
+
+const GrafanaDashboardApiVersion = "grafana.integreatly.org/v1beta1"
+
+var defaultSidecarLabels = map[string]string{"grafana_dashboard": "1"}
+var defaultInstanceSelector = map[string]string{"dashboards": "grafana"}
+
+// Stands in for the namespace until the delimiters have been escaped, so that Helm sees just this action
+const helmNamespacePlaceholder = "boulevard-helm-release-namespace"
+
+var helmDelimitersUnescaper = strings.NewReplacer(`{{"{{"}}`, "{{", `{{"}}"}}`, "}}")
+
+// DashboardResourceOptions wraps each dashboard in a Kubernetes resource, instead of writing plain JSON
+type DashboardResourceOptions struct {
+	Format           string   // Defaults to DashboardJsonFormat
+	Name             string   // Defaults to the dashboard UID
+	Namespace        string   // Defaults to the Helm release namespace for a Helm template, otherwise none
+	Labels           []string // key=value, added to grafana_dashboard=1 for a ConfigMap
+	Annotations      []string // key=value
+	FolderAnnotation string   // ConfigMap annotation the sidecar reads the folder from, defaults to DefaultFolderAnnotation
+	InstanceSelector []string // key=value labels of the Grafana instances for a GrafanaDashboard, defaults to dashboards=grafana
+	HelmTemplate     bool     // Escape the dashboard for Helm, e.g. within a chart's templates
+}
+
+func (o DashboardResourceOptions) format() string {
+	if o.Format == "" {
+		return DashboardJsonFormat
+	}
+	return o.Format
+}
+
+type ConfigMapResource struct {
+	ApiVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   ObjectMeta        `yaml:"metadata"`
+	Data       map[string]string `yaml:"data"`
+}
+
+// GrafanaDashboardResource https://grafana.github.io/grafana-operator/docs/api/#grafanadashboard
+type GrafanaDashboardResource struct {
+	ApiVersion string               `yaml:"apiVersion"`
+	Kind       string               `yaml:"kind"`
+	Metadata   ObjectMeta           `yaml:"metadata"`
+	Spec       GrafanaDashboardSpec `yaml:"spec"`
+}
+
+type GrafanaDashboardSpec struct {
+	InstanceSelector LabelSelector `yaml:"instanceSelector"`
+	Folder           string        `yaml:"folder,omitempty"`
+	Json             string        `yaml:"json"`
+}
+
+type LabelSelector struct {
+	MatchLabels map[string]string `yaml:"matchLabels"`
+}
+
+func (dg *DashboardGenerator) checkDashboardResourceFormat() error {
+	switch dg.DashboardResource.format() {
+	case DashboardJsonFormat, ConfigMapFormat, GrafanaDashboardFormat:
+		return nil
+	}
+	return fmt.Errorf("unsupported dashboard format %q, expected one of %s, %s, %s", dg.DashboardResource.Format, DashboardJsonFormat, ConfigMapFormat, GrafanaDashboardFormat)
+}
+
+// wrapDashboard puts the dashboard JSON into the configured resource, named after the dashboard and any part of it
+func (dg *DashboardGenerator) wrapDashboard(destFilePath string, part string, output []byte) ([]byte, error) {
+	opts := dg.DashboardResource
+
+	meta := ObjectMeta{Name: stringOrDefault(opts.Name, kubernetesResourceName(dg.dashboardUid())), Namespace: opts.Namespace}
+	if part != "" {
+		meta.Name += "-" + kubernetesResourceName(part)
+	}
+	if meta.Namespace == "" && opts.HelmTemplate {
+		meta.Namespace = helmNamespacePlaceholder
+	}
+	if len(opts.Annotations) > 0 {
+		meta.Annotations = keyValuePairs(opts.Annotations)
+	}
+
+	var resource interface{}
+
+	switch opts.format() {
+	case ConfigMapFormat:
+		meta.Labels = mergedLabels(defaultSidecarLabels, keyValuePairs(opts.Labels))
+		if meta.Annotations == nil {
+			meta.Annotations = make(map[string]string)
+		}
+		meta.Annotations[stringOrDefault(opts.FolderAnnotation, DefaultFolderAnnotation)] = dg.dashboardFolder()
+
+		key := strings.TrimSuffix(filepath.Base(destFilePath), filepath.Ext(destFilePath)) + ".json"
+		resource = &ConfigMapResource{ApiVersion: "v1", Kind: "ConfigMap", Metadata: meta, Data: map[string]string{key: string(output)}}
+	case GrafanaDashboardFormat:
+		if len(opts.Labels) > 0 {
+			meta.Labels = keyValuePairs(opts.Labels)
+		}
+
+		selector := defaultInstanceSelector
+		if len(opts.InstanceSelector) > 0 {
+			selector = keyValuePairs(opts.InstanceSelector)
+		}
+
+		resource = &GrafanaDashboardResource{ApiVersion: GrafanaDashboardApiVersion, Kind: "GrafanaDashboard", Metadata: meta,
+			Spec: GrafanaDashboardSpec{InstanceSelector: LabelSelector{MatchLabels: selector}, Folder: dg.dashboardFolder(), Json: string(output)}}
+	default:
+		return output, nil
+	}
+
+	wrapped, err := yaml.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	if !opts.HelmTemplate {
+		return wrapped, nil
+	}
+
+	// Legend formats like {{instance}} are Helm actions too
+	escaped := helmDelimitersEscaper.Replace(string(wrapped))
+	return []byte(strings.Replace(escaped, helmNamespacePlaceholder, `"{{ .Release.Namespace }}"`, 1)), nil
+}
+
+// unwrapDashboard finds the dashboard JSON in an existing resource, for merging into
+func (dg *DashboardGenerator) unwrapDashboard(existing []byte) ([]byte, error) {
+	return dg.DashboardResource.unwrap(existing)
+}
+
+// unwrap finds the dashboard JSON in a resource written in these options' format
+func (o DashboardResourceOptions) unwrap(existing []byte) ([]byte, error) {
+	format := o.format()
+	if format == DashboardJsonFormat {
+		return existing, nil
+	}
+
+	text := string(existing)
+	if o.HelmTemplate {
+		text = helmDelimitersUnescaper.Replace(strings.Replace(text, `"{{ .Release.Namespace }}"`, helmNamespacePlaceholder, 1))
+	}
+
+	if format == ConfigMapFormat {
+		var configMap ConfigMapResource
+		if err := yaml.Unmarshal([]byte(text), &configMap); err != nil {
+			return nil, fmt.Errorf("existing dashboard is not a ConfigMap: %v", err)
+		}
+		for _, each := range configMap.Data {
+			return []byte(each), nil
+		}
+		return nil, fmt.Errorf("existing ConfigMap has no dashboard")
+	}
+
+	var resource GrafanaDashboardResource
+	if err := yaml.Unmarshal([]byte(text), &resource); err != nil {
+		return nil, fmt.Errorf("existing dashboard is not a GrafanaDashboard: %v", err)
+	}
+	return []byte(resource.Spec.Json), nil
+}
+
+func mergedLabels(defaults map[string]string, labels map[string]string) map[string]string {
+	merged := make(map[string]string, len(defaults)+len(labels))
+	for k, v := range defaults {
+		merged[k] = v
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	return merged
+}
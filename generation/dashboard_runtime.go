@@ -0,0 +1,130 @@
+package generation
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const runtimeRow = "Runtime"
+
+// fdUsageSeries names the fraction of file descriptors in use, for linking the exhaustion alert to its panel
+const fdUsageSeries = "process_open_fds/process_max_fds"
+
+// RuntimeOptions adds the Go runtime and process metrics that every service exposes through the default registry
+type RuntimeOptions struct {
+	Row                    bool
+	Job                    string  // Scrape job for the alerts, which can't use the dashboard's variables
+	GoroutineLeakThreshold int     // Alert when an instance has more goroutines than this, if set
+	FdExhaustionRatio      float64 // Alert when an instance has used more than this fraction of its file descriptors, if set
+	ServiceDown            bool    // Alert when no instance of the job is up, suppressing the error alerts meanwhile
+	ServiceDownSeverity    string  // Defaults to the severity of the alert defaults
+	ServiceDownTeam        string  // Defaults to the team of the alert defaults
+	ServiceDownDuration    string  // Defaults to 2m
+}
+
+func (dg *DashboardGenerator) runtimePanels() []layoutEntry {
+	goroutines := &metric{FullMetricName: "go_goroutines"}
+	gcDuration := &metric{FullMetricName: "go_gc_duration_seconds"}
+	heap := &metric{FullMetricName: "go_memstats_heap_inuse_bytes"}
+	cpu := &metric{FullMetricName: "process_cpu_seconds_total"}
+	openFds := &metric{FullMetricName: "process_open_fds"}
+	maxFds := &metric{FullMetricName: "process_max_fds"}
+
+	fdUsage := fmt.Sprintf("sum(%s%s) by (instance) / sum(%s%s) by (instance)",
+		openFds.FullMetricName, dg.metricSelector(openFds), maxFds.FullMetricName, dg.metricSelector(maxFds))
+
+	gcPause := fmt.Sprintf("sum(rate(%s_sum%s[$__rate_interval])) by (instance) / sum(rate(%s_count%s[$__rate_interval])) by (instance)",
+		gcDuration.FullMetricName, dg.metricSelector(gcDuration), gcDuration.FullMetricName, dg.metricSelector(gcDuration))
+
+	entries := []layoutEntry{
+		{panel: dg.runtimePanel("Goroutines", defaultUnit, "", dg.byInstance("sum(%s%s)", goroutines)), key: "runtime/goroutines", metricNames: []string{goroutines.FullMetricName}},
+		{panel: dg.runtimePanel("GC pause (average)", "s", "", gcPause), key: "runtime/gc"},
+		{panel: dg.runtimePanel("Heap in use", "bytes", "", dg.byInstance("sum(%s%s)", heap)), key: "runtime/heap"},
+		{panel: dg.runtimePanel("CPU", defaultUnit, "cores", dg.byInstance("sum(rate(%s%s[$__rate_interval]))", cpu)), key: "runtime/cpu"},
+		{panel: dg.runtimePanel("Open file descriptors", defaultUnit, "", dg.byInstance("sum(%s%s)", openFds), dg.byInstance("sum(%s%s)", maxFds)), key: "runtime/fds", metricNames: []string{openFds.FullMetricName}},
+		{panel: dg.runtimePanel("File descriptors used", "percentunit", "", fdUsage), key: "runtime/fdusage", metricNames: []string{fdUsageSeries}},
+	}
+
+	for i := range entries {
+		entries[i].row = runtimeRow
+	}
+	return entries
+}
+
+func (dg *DashboardGenerator) byInstance(format string, m *metric) string {
+	return fmt.Sprintf(format, m.FullMetricName, dg.metricSelector(m)) + " by (instance)"
+}
+
+// runtimePanel shows each instance separately, with the first expression as the value and any second as its limit
+func (dg *DashboardGenerator) runtimePanel(title string, unit string, axisLabel string, exprs ...string) *Panel {
+	var targets []Target
+	for i, each := range exprs {
+		legend := "{{instance}}"
+		if i > 0 {
+			legend = "{{instance}} limit"
+		}
+		targets = append(targets, Target{Expr: each, LegendFormat: legend, RefId: string(rune('A' + i))})
+	}
+
+	if dg.legacyPanels() {
+		for i := range targets {
+			targets[i].IntervalFactor = 1
+		}
+		return newGraphPanel(legacyDatasource, title, unit, targets...)
+	}
+
+	panel := newTimeseriesPanel(dg.datasource(), title, FieldDefaults{Unit: unit, Min: floatPtr(0)}, targets...)
+	if axisLabel != "" {
+		panel = withAxisLabel(panel, axisLabel)
+	}
+	return panel
+}
+
+// addRuntimeAlertRules adds the service down, goroutine leak and file descriptor exhaustion alerts, if wanted
+func (dg *DashboardGenerator) addRuntimeAlertRules() error {
+	if !dg.Runtime.ServiceDown && dg.Runtime.GoroutineLeakThreshold <= 0 && dg.Runtime.FdExhaustionRatio <= 0 {
+		return nil
+	}
+
+	if dg.Runtime.Job == "" {
+		return fmt.Errorf("runtime alerts need a job")
+	}
+
+	job := fmt.Sprintf(`job="%s"`, dg.Runtime.Job)
+
+	if dg.Runtime.ServiceDown {
+		props := map[string]string{"name": "serviceDown", "timeRange": "5m", "duration": "2m", "summary": "Service down", "description": "No instance of job " + dg.Runtime.Job + " is up"}
+		for k, v := range map[string]string{"severity": dg.Runtime.ServiceDownSeverity, "team": dg.Runtime.ServiceDownTeam, "duration": dg.Runtime.ServiceDownDuration} {
+			if v != "" {
+				props[k] = v
+			}
+		}
+
+		dg.alertRules = append(dg.alertRules, RuntimeAlertRule{
+			props:       props,
+			query:       fmt.Sprintf("absent(up{%s} == 1)", job),
+			threshold:   "0",
+			serviceDown: true,
+		})
+	}
+
+	if dg.Runtime.GoroutineLeakThreshold > 0 {
+		dg.alertRules = append(dg.alertRules, RuntimeAlertRule{
+			props:     map[string]string{"name": "goroutineLeak", "timeRange": "15m", "duration": "15m", "summary": "Goroutines may be leaking", "description": "An instance has had more goroutines than expected for 15 minutes"},
+			query:     fmt.Sprintf("max by (instance) (go_goroutines{%s})", job),
+			threshold: strconv.Itoa(dg.Runtime.GoroutineLeakThreshold),
+			panel:     "go_goroutines",
+		})
+	}
+
+	if dg.Runtime.FdExhaustionRatio > 0 {
+		dg.alertRules = append(dg.alertRules, RuntimeAlertRule{
+			props:     map[string]string{"name": "fdExhaustion", "timeRange": "5m", "duration": "5m", "summary": "File descriptors nearly exhausted", "description": "An instance has nearly run out of file descriptors"},
+			query:     fmt.Sprintf("max by (instance) (process_open_fds{%s} / process_max_fds{%s})", job, job),
+			threshold: strconv.FormatFloat(dg.Runtime.FdExhaustionRatio, 'f', -1, 64),
+			panel:     fdUsageSeries,
+		})
+	}
+
+	return nil
+}
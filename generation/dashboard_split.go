@@ -0,0 +1,216 @@
+package generation
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	SingleDashboard        = "none"
+	DashboardsByPackage    = "package"
+	DashboardsByAnnotation = "annotation"
+	OverviewAndDetail      = "overview" // Overview of every metric, with detail by annotated group, otherwise by package
+)
+
+const dashboardProviderFileName = "dashboard_provider.yaml"
+
+// DashboardSplitOptions spreads the dashboard across several, which share a tag and link to each other
+type DashboardSplitOptions struct {
+	By              string // Defaults to SingleDashboard
+	Folder          string // Grafana folder to provision the dashboards into, defaults to the display prefix
+	ProvisionedPath string // Where Grafana finds the dashboard files, defaults to /var/lib/grafana/dashboards/<folder>
+}
+
+func (o DashboardSplitOptions) by() string {
+	if o.By == "" {
+		return SingleDashboard
+	}
+	return o.By
+}
+
+// dashboardPart is the content of one dashboard
+type dashboardPart struct {
+	name            string // Blank for the main dashboard
+	metrics         []*metric
+	externalMetrics []*metric
+	overview        bool
+}
+
+// dashboardParts divides the metrics between dashboards. The main dashboard comes first, with whatever is in no group.
+func (dg *DashboardGenerator) dashboardParts(metrics []*metric, externalMetrics []*metric) ([]*dashboardPart, error) {
+	main := &dashboardPart{externalMetrics: externalMetrics}
+
+	var groupOf func(m *metric) string
+
+	switch dg.Dashboards.by() {
+	case SingleDashboard:
+		main.metrics = metrics
+		return []*dashboardPart{main}, nil
+	case DashboardsByPackage:
+		groupOf = func(m *metric) string { return path.Base(m.PackagePath) }
+	case DashboardsByAnnotation:
+		groupOf = func(m *metric) string { return groupFor(dg.dashboardGroups, m) }
+	case OverviewAndDetail:
+		main.metrics = metrics
+		main.overview = true
+
+		if len(dg.dashboardGroups) > 0 {
+			groupOf = func(m *metric) string { return groupFor(dg.dashboardGroups, m) }
+		} else {
+			groupOf = func(m *metric) string { return path.Base(m.PackagePath) }
+		}
+	default:
+		return nil, fmt.Errorf("unsupported dashboard split %q, expected one of %s, %s, %s, %s", dg.Dashboards.By, SingleDashboard, DashboardsByPackage, DashboardsByAnnotation, OverviewAndDetail)
+	}
+
+	parts := []*dashboardPart{main}
+	byName := make(map[string]*dashboardPart)
+
+	for _, each := range metrics {
+		name := groupOf(each)
+		if name == "" {
+			if !main.overview {
+				main.metrics = append(main.metrics, each)
+			}
+			continue
+		}
+
+		part, ok := byName[name]
+		if !ok {
+			part = &dashboardPart{name: name}
+			byName[name] = part
+			parts = append(parts, part)
+		}
+		part.metrics = append(part.metrics, each)
+	}
+
+	return parts, nil
+}
+
+func groupFor(groups []panelGroup, m *metric) string {
+	for _, group := range groups {
+		for _, each := range group.metrics {
+			if each == m.PanelTitle || each == m.normalisedMetricName || each == m.FullMetricName {
+				return group.name
+			}
+		}
+	}
+	return ""
+}
+
+func (p *dashboardPart) title(mainTitle string) string {
+	if p.name == "" {
+		return mainTitle
+	}
+	return mainTitle + ": " + p.name
+}
+
+const maxGrafanaUidLength = 40
+const maxPartSlugLength = 20
+
+// partUid adds the part's name to the main dashboard's UID, keeping within Grafana's 40 characters. The part's name is
+// always there in full or in part, so its UID can't be the main one.
+func (dg *DashboardGenerator) partUid(part *dashboardPart) string {
+	uid := dg.dashboardUid()
+	if part.name == "" {
+		return uid
+	}
+
+	slug := dashboardSlug(part.name)
+	if len(slug) > maxPartSlugLength {
+		slug = strings.TrimRight(slug[:maxPartSlugLength], "-_")
+	}
+
+	if maxLength := maxGrafanaUidLength - len(slug) - 1; len(uid) > maxLength {
+		uid = uid[:maxLength]
+	}
+	return uid + "-" + slug
+}
+
+func partPath(destFilePath string, part *dashboardPart) string {
+	if part.name == "" {
+		return destFilePath
+	}
+
+	ext := filepath.Ext(destFilePath)
+	return strings.TrimSuffix(destFilePath, ext) + "_" + strings.ReplaceAll(dashboardSlug(part.name), "-", "_") + ext
+}
+
+func dashboardSlug(name string) string {
+	return strings.Trim(invalidGrafanaUidChars.ReplaceAllString(strings.ToLower(name), "-"), "-_")
+}
+
+// sharedTag is on every one of the dashboards, for them to link to each other by
+func (dg *DashboardGenerator) sharedTag() string {
+	return normaliseAndLowercaseName(dg.displayStringOrDefault(dg.rawMetricPrefix))
+}
+
+func (dg *DashboardGenerator) linkDashboards(dashboard *Dashboard) {
+	tag := dg.sharedTag()
+	if !containsString(dashboard.Tags, tag) {
+		dashboard.Tags = append(dashboard.Tags, tag)
+	}
+
+	dashboard.Links = append(dashboard.Links, DashboardLink{Title: "Dashboards", Type: "dashboards", Tags: []string{tag}, AsDropdown: true, IncludeVars: true, KeepTime: true})
+}
+
+// DashboardProvisioning https://grafana.com/docs/grafana/latest/administration/provisioning/#dashboards
+type DashboardProvisioning struct {
+	ApiVersion int                 `yaml:"apiVersion"`
+	Providers  []DashboardProvider `yaml:"providers"`
+}
+
+type DashboardProvider struct {
+	Name            string                   `yaml:"name"`
+	OrgId           int                      `yaml:"orgId"`
+	Folder          string                   `yaml:"folder"`
+	Type            string                   `yaml:"type"`
+	DisableDeletion bool                     `yaml:"disableDeletion"`
+	AllowUiUpdates  bool                     `yaml:"allowUiUpdates"`
+	Options         DashboardProviderOptions `yaml:"options"`
+}
+
+type DashboardProviderOptions struct {
+	Path string `yaml:"path"`
+}
+
+func (dg *DashboardGenerator) dashboardFolder() string {
+	if dg.Dashboards.Folder == "" {
+		return dg.displayPrefix(dg.currentMetricPrefix)
+	}
+	return dg.Dashboards.Folder
+}
+
+func (dg *DashboardGenerator) dashboardProvisioning() DashboardProvisioning {
+	folder := dg.dashboardFolder()
+
+	provisionedPath := dg.Dashboards.ProvisionedPath
+	if provisionedPath == "" {
+		provisionedPath = "/var/lib/grafana/dashboards/" + dashboardSlug(folder)
+	}
+
+	return DashboardProvisioning{ApiVersion: 1, Providers: []DashboardProvider{{
+		Name:    dg.dashboardUid(),
+		OrgId:   1,
+		Folder:  folder,
+		Type:    "file",
+		Options: DashboardProviderOptions{Path: provisionedPath},
+	}}}
+}
+
+func (dg *DashboardGenerator) writeDashboardProvisioning(destFilePath string) error {
+	providerPath := filepath.Join(filepath.Dir(destFilePath), dashboardProviderFileName)
+
+	output, err := yaml.Marshal(dg.dashboardProvisioning())
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Writing dashboard provisioning to", FriendlyFileName(providerPath))
+	return os.WriteFile(providerPath, output, 0644)
+}
@@ -0,0 +1,190 @@
+package generation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// DashboardTemplateData is what a custom dashboard template is executed against
+type DashboardTemplateData struct {
+	Title           string
+	Uid             string
+	Tags            []string
+	SchemaVersion   int
+	Metrics         []*metric
+	ExternalMetrics []*metric
+	Dashboard       *Dashboard // The dashboard boulevard would otherwise have generated
+}
+
+// templateFuncs are the functions available to custom dashboard templates:
+//
+//	metric NAME            the discovered metric with the given name, full or as in the source
+//	metricsOfType TYPE     discovered metrics of type counter, gauge, errors, histogram, summary or timer
+//	quote STRING           the string as a quoted JSON string
+//	jsonEscape STRING      the string escaped for use inside a JSON string
+//	json VALUE             any value as JSON, e.g. `json .Dashboard.Panels`
+//	selector METRIC        the label matchers for the dashboard's variables, e.g. `{job=~"$job",...}`
+//	unit METRIC            the metric's Grafana unit
+//	rateUnit METRIC        the Grafana unit for the metric's per-second rate
+//	datasource             the panel datasource, as JSON
+//	panelId KEY METRIC...  a stable panel id for the key, for one panel only. Alerts on the metrics link to that panel.
+//	panelRef KEY           the id already given to the key's panel, e.g. for a link to it
+//	gridPos PANELTYPE      the next position for a panel of the type (or "row"), as JSON
+func (dg *DashboardGenerator) templateFuncs(data *DashboardTemplateData, linkedPanelIds map[string]int) template.FuncMap {
+	cursor := layoutCursor{}
+	panelIds := make(map[string]int)
+	usedPanelIds := make(map[int]bool)
+
+	return template.FuncMap{
+		"metric": func(name string) (*metric, error) {
+			for _, each := range data.Metrics {
+				if name == each.PanelTitle || name == each.normalisedMetricName || name == each.FullMetricName {
+					return each, nil
+				}
+			}
+			return nil, fmt.Errorf("no metric %s", name)
+		},
+		"metricsOfType": func(metricType string) []*metric {
+			var metrics []*metric
+			for _, each := range data.Metrics {
+				if each.MetricType == metricType {
+					metrics = append(metrics, each)
+				}
+			}
+			return metrics
+		},
+		"quote": func(value string) (string, error) {
+			return marshalTemplateValue(value)
+		},
+		"jsonEscape": func(value string) (string, error) {
+			quoted, err := marshalTemplateValue(value)
+			return strings.TrimSuffix(strings.TrimPrefix(quoted, `"`), `"`), err
+		},
+		"json":     marshalTemplateValue,
+		"selector": func(m *metric) string { return dg.metricSelector(m) },
+		"unit":     dg.unit,
+		"rateUnit": dg.rateUnit,
+		"datasource": func() (string, error) {
+			if dg.legacyPanels() {
+				return marshalTemplateValue(legacyDatasource)
+			}
+			return marshalTemplateValue(dg.datasource())
+		},
+		"panelId": func(key string, metricNames ...string) (int, error) {
+			if _, ok := panelIds[key]; ok {
+				return 0, fmt.Errorf("panelId %q is already taken, use panelRef to refer to its panel", key)
+			}
+
+			id := freePanelId(key, usedPanelIds)
+			panelIds[key] = id
+
+			for _, each := range metricNames {
+				if _, ok := linkedPanelIds[each]; !ok {
+					linkedPanelIds[each] = id
+				}
+			}
+			return id, nil
+		},
+		"panelRef": func(key string) (int, error) {
+			if id, ok := panelIds[key]; ok {
+				return id, nil
+			}
+			return 0, fmt.Errorf("no panelId %q yet", key)
+		},
+		"gridPos": func(panelType string) (string, error) {
+			size := rowSize
+			if panelType != "row" {
+				var err error
+				if size, err = dg.Layout.panelSize(panelType); err != nil {
+					return "", err
+				}
+			}
+			return marshalTemplateValue(cursor.place(size))
+		},
+	}
+}
+
+// renderDashboardTemplate executes the custom template, insisting that the result is valid JSON. Alerts then link to the
+// panels given metrics by the template, or to generated panels that it kept.
+func (dg *DashboardGenerator) renderDashboardTemplate(templatePath string, data *DashboardTemplateData) ([]byte, error) {
+	linkedPanelIds := make(map[string]int)
+
+	tmpl, err := template.New(filepath.Base(templatePath)).Funcs(dg.templateFuncs(data, linkedPanelIds)).ParseFiles(templatePath)
+	if err != nil {
+		return nil, fmt.Errorf("bad dashboard template: %v", err)
+	}
+
+	buf := bytes.Buffer{}
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("dashboard template failed: %v", err)
+	}
+
+	output := buf.Bytes()
+
+	var parsed jsonObject
+	if err := json.Unmarshal(output, &parsed); err != nil {
+		return nil, fmt.Errorf("dashboard template %s produced invalid JSON: %s", templatePath, describeJsonError(output, err))
+	}
+
+	generatedIds := make(map[int]bool)
+	collectGeneratedPanelIds(objects(parsed["panels"]), generatedIds)
+
+	for name, id := range dg.panelIds {
+		if _, ok := linkedPanelIds[name]; !ok && generatedIds[id] {
+			linkedPanelIds[name] = id
+		}
+	}
+	dg.panelIds = linkedPanelIds
+
+	return output, nil
+}
+
+func collectGeneratedPanelIds(panels []jsonObject, ids map[int]bool) {
+	for _, each := range panels {
+		if key, _ := ownership(each); key != "" {
+			ids[number(each["id"])] = true
+		}
+		collectGeneratedPanelIds(objects(each["panels"]), ids)
+	}
+}
+
+// describeJsonError locates a syntax error, showing the line it's on
+func describeJsonError(data []byte, err error) string {
+	syntaxErr, ok := err.(*json.SyntaxError)
+	if !ok {
+		return err.Error()
+	}
+
+	offset := int(syntaxErr.Offset)
+	if offset > len(data) {
+		offset = len(data)
+	}
+
+	line := bytes.Count(data[:offset], []byte("\n")) + 1
+	lineStart := bytes.LastIndexByte(data[:offset], '\n') + 1
+	lineEnd := bytes.IndexByte(data[offset:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(data)
+	} else {
+		lineEnd += offset
+	}
+
+	return fmt.Sprintf("%v at line %d, column %d:\n  %s", err, line, offset-lineStart+1, strings.TrimSpace(string(data[lineStart:lineEnd])))
+}
+
+func marshalTemplateValue(value interface{}) (string, error) {
+	buf := bytes.Buffer{}
+
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
@@ -0,0 +1,230 @@
+package generation
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const gridColumns = 24
+
+// panelTypeSchemaVersions are the built-in panel types, with the schema version of the Grafana release that added them.
+// Any removed since have the version they were migrated away at. Kept by hand from Grafana's release notes, not taken
+// from its schema, so this is a partial check.
+var panelTypeSchemaVersions = map[string]struct{ since, until int }{
+	"alertGroups":    {},
+	"alertlist":      {},
+	"annolist":       {},
+	"dashlist":       {},
+	"debug":          {},
+	"gettingstarted": {},
+	"graph":          {},
+	"heatmap":        {},
+	"live":           {},
+	"news":           {},
+	"pluginlist":     {},
+	"row":            {},
+	"singlestat":     {until: 28},
+	"table":          {},
+	"text":           {},
+	"welcome":        {},
+	"bargauge":       {since: 18},
+	"gauge":          {since: 18},
+	"logs":           {since: 18},
+	"stat":           {since: 25},
+	"nodeGraph":      {since: 27},
+	"piechart":       {since: 27},
+	"timeseries":     {since: 27},
+	"barchart":       {since: 30},
+	"histogram":      {since: 30},
+	"state-timeline": {since: 30},
+	"status-history": {since: 30},
+	"candlestick":    {since: 36},
+	"canvas":         {since: 36},
+	"geomap":         {since: 36},
+	"traces":         {since: 36},
+	"datagrid":       {since: 38},
+	"flamegraph":     {since: 38},
+	"trend":          {since: 38},
+	"xychart":        {since: 38},
+}
+
+// DashboardValidationError lists everything wrong with a dashboard, rather than just the first problem
+type DashboardValidationError struct {
+	Dashboard     string
+	SchemaVersion int
+	Problems      []string
+}
+
+func (e *DashboardValidationError) Error() string {
+	return fmt.Sprintf("dashboard %s is not valid for schema version %d:\n  - %s", e.Dashboard, e.SchemaVersion, strings.Join(e.Problems, "\n  - "))
+}
+
+// validateDashboard checks the final JSON, however it was produced, for what would stop Grafana loading it as intended.
+// It's checked against its own schemaVersion, e.g. that of the existing dashboard it was merged into, or the one given.
+// The type of a panel boulevard didn't generate, e.g. one added by hand, can only be warned about, as the list of
+// types is partial.
+func validateDashboard(name string, output []byte, schemaVersion int) ([]string, error) {
+	var dashboard jsonObject
+	if err := json.Unmarshal(output, &dashboard); err != nil {
+		return nil, fmt.Errorf("dashboard %s is not valid JSON: %v", name, err)
+	}
+
+	if version, ok := dashboard["schemaVersion"].(float64); ok {
+		schemaVersion = int(version)
+	}
+
+	v := dashboardValidation{schemaVersion: schemaVersion, ids: make(map[int]string)}
+
+	for _, each := range []string{"title", "uid"} {
+		if value, ok := dashboard[each].(string); !ok || value == "" {
+			v.problem("dashboard has no %s", each)
+		}
+	}
+
+	if _, ok := dashboard["schemaVersion"].(float64); !ok {
+		v.problem("dashboard has no schemaVersion")
+	}
+
+	if _, ok := dashboard["panels"].([]interface{}); !ok {
+		v.problem("dashboard has no panels list")
+	}
+
+	panels := objects(dashboard["panels"])
+	v.checkPanels(panels)
+
+	for _, each := range panels {
+		if nested := objects(each["panels"]); len(nested) > 0 {
+			v.checkPanels(nested)
+		}
+	}
+
+	if len(v.problems) > 0 {
+		return v.warnings, &DashboardValidationError{Dashboard: name, SchemaVersion: schemaVersion, Problems: v.problems}
+	}
+	return v.warnings, nil
+}
+
+type dashboardValidation struct {
+	schemaVersion int
+	ids           map[int]string // Every panel's description, by id, across the whole dashboard
+	problems      []string
+	warnings      []string
+}
+
+func (v *dashboardValidation) problem(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+func (v *dashboardValidation) warning(format string, args ...interface{}) {
+	v.warnings = append(v.warnings, fmt.Sprintf(format, args...))
+}
+
+// checkPanels checks the panels at one level, either the top or nested in a collapsed row, which mustn't overlap
+func (v *dashboardValidation) checkPanels(panels []jsonObject) {
+	type placedPanel struct {
+		desc string
+		pos  GridPos
+	}
+	var placed []placedPanel
+
+	for i, each := range panels {
+		desc := describePanel(i, each)
+
+		v.checkType(desc, each)
+
+		if id, ok := each["id"].(float64); !ok {
+			v.problem("%s has no id", desc)
+		} else if other, dup := v.ids[int(id)]; dup {
+			v.problem("%s has the same id as %s", desc, other)
+		} else {
+			v.ids[int(id)] = desc
+		}
+
+		if v.checkGridPos(desc, each) {
+			placed = append(placed, placedPanel{desc, gridPos(each)})
+		}
+	}
+
+	for i, a := range placed {
+		for _, b := range placed[i+1:] {
+			if overlaps(a.pos, b.pos) {
+				v.problem("%s overlaps %s", a.desc, b.desc)
+			}
+		}
+	}
+}
+
+func (v *dashboardValidation) checkType(desc string, panel jsonObject) {
+	if _, ok := panel["libraryPanel"].(jsonObject); ok {
+		return // Its type is in the library
+	}
+
+	report := v.problem
+	if key, _ := ownership(panel); key == "" {
+		report = v.warning
+	}
+
+	panelType, ok := panel["type"].(string)
+	if !ok || panelType == "" {
+		report("%s has no type", desc)
+		return
+	}
+
+	if strings.HasSuffix(panelType, "-panel") {
+		return // A plugin, which we can't know about
+	}
+
+	versions, known := panelTypeSchemaVersions[panelType]
+	switch {
+	case !known:
+		report("%s has unknown type %q", desc, panelType)
+	case v.schemaVersion < versions.since:
+		report("%s has type %q, which needs schema version %d", desc, panelType, versions.since)
+	case versions.until > 0 && v.schemaVersion >= versions.until:
+		report("%s has type %q, which was replaced at schema version %d", desc, panelType, versions.until)
+	}
+}
+
+// checkGridPos says whether the panel has a usable position
+func (v *dashboardValidation) checkGridPos(desc string, panel jsonObject) bool {
+	pos, ok := panel["gridPos"].(jsonObject)
+	if !ok {
+		v.problem("%s has no gridPos", desc)
+		return false
+	}
+
+	var missing []string
+	for _, each := range []string{"h", "w", "x", "y"} {
+		if _, ok := pos[each].(float64); !ok {
+			missing = append(missing, each)
+		}
+	}
+	if len(missing) > 0 {
+		v.problem("%s has no gridPos %s", desc, strings.Join(missing, ", "))
+		return false
+	}
+
+	p := gridPos(panel)
+	if p.W < 1 || p.H < 1 || p.X < 0 || p.Y < 0 || p.X+p.W > gridColumns {
+		v.problem("%s has gridPos %+v, outside the %d-column grid", desc, p, gridColumns)
+		return false
+	}
+	return true
+}
+
+func gridPos(panel jsonObject) GridPos {
+	pos, _ := panel["gridPos"].(jsonObject)
+	return GridPos{H: number(pos["h"]), W: number(pos["w"]), X: number(pos["x"]), Y: number(pos["y"])}
+}
+
+func overlaps(a GridPos, b GridPos) bool {
+	return a.X < b.X+b.W && b.X < a.X+a.W && a.Y < b.Y+b.H && b.Y < a.Y+a.H
+}
+
+func describePanel(index int, panel jsonObject) string {
+	if id, ok := panel["id"].(float64); ok {
+		return fmt.Sprintf("panel %d %q", int(id), panelTitle(panel))
+	}
+	return fmt.Sprintf("panel #%d %q", index+1, panelTitle(panel))
+}
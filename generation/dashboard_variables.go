@@ -0,0 +1,113 @@
+package generation
+
+import (
+	"fmt"
+	"strings"
+)
+
+const datasourceVariable = "datasource"
+
+// Variables for the scrape target, in the order they are chained
+var standardVariables = []string{"namespace", "job", "instance"}
+
+// Labels that never get their own variable
+var reservedLabelNames = map[string]bool{"namespace": true, "job": true, "instance": true, "quantile": true, "le": true}
+
+// VariableOptions controls the dashboard's template variables, beyond the datasource, namespace, job and instance
+type VariableOptions struct {
+	LabelVariables bool // One variable per discovered metric label, e.g. `city`
+}
+
+// templateVariables lets the user pick the datasource and scrape targets, then any metric labels
+func (dg *DashboardGenerator) templateVariables(metrics []*metric) []*TemplateVariable {
+	variables := []*TemplateVariable{{
+		Name:    datasourceVariable,
+		Label:   "Data source",
+		Type:    "datasource",
+		Query:   "prometheus",
+		Current: map[string]string{"text": dg.datasourceUid(), "value": dg.datasourceUid()},
+		Options: []interface{}{},
+	}}
+
+	// Each variable is limited by those before it
+	var matchers []string
+	for _, each := range standardVariables {
+		variables = append(variables, dg.queryVariable(each, fmt.Sprintf("label_values(up{%s}, %s)", strings.Join(matchers, ","), each)))
+		matchers = append(matchers, variableMatcher(each))
+	}
+
+	if dg.Variables.LabelVariables {
+		for _, label := range dg.labelVariableNames(metrics) {
+			for _, m := range metrics {
+				if containsString(m.LabelNames, label) {
+					variables = append(variables, dg.queryVariable(label, fmt.Sprintf("label_values(%s{%s}, %s)", m.FullMetricName, strings.Join(matchers, ","), label)))
+					break
+				}
+			}
+		}
+	}
+
+	return variables
+}
+
+func (dg *DashboardGenerator) queryVariable(name string, query string) *TemplateVariable {
+	var datasource interface{} = dg.datasource()
+	if dg.legacyPanels() {
+		datasource = legacyDatasource
+	}
+
+	return &TemplateVariable{
+		Name:       name,
+		Type:       "query",
+		Datasource: datasource,
+		Query:      query,
+		Definition: query,
+		Refresh:    2, // On time range change
+		Multi:      true,
+		IncludeAll: true,
+		AllValue:   ".*",
+		Current:    map[string]interface{}{"text": []string{"All"}, "value": []string{"$__all"}},
+		Options:    []interface{}{},
+		Sort:       1,
+	}
+}
+
+// labelVariableNames lists the distinct metric labels, in discovery order
+func (dg *DashboardGenerator) labelVariableNames(metrics []*metric) []string {
+	var names []string
+	for _, m := range metrics {
+		for _, each := range m.LabelNames {
+			if !reservedLabelNames[each] && !containsString(names, each) {
+				names = append(names, each)
+			}
+		}
+	}
+	return names
+}
+
+// metricSelector filters the metric by the selected variable values, after any fixed filter, and before any extra matchers
+func (dg *DashboardGenerator) metricSelector(m *metric, extraMatchers ...string) string {
+	var matchers []string
+
+	if filter := strings.TrimSuffix(m.ExtraLabelFilter, ","); filter != "" {
+		matchers = append(matchers, filter)
+	}
+
+	for _, each := range standardVariables {
+		matchers = append(matchers, variableMatcher(each))
+	}
+
+	if dg.Variables.LabelVariables {
+		for _, each := range m.LabelNames {
+			if !reservedLabelNames[each] {
+				matchers = append(matchers, variableMatcher(each))
+			}
+		}
+	}
+
+	return "{" + strings.Join(append(matchers, extraMatchers...), ",") + "}"
+}
+
+func variableMatcher(name string) string {
+	return fmt.Sprintf(`%s=~"$%s"`, name, name)
+}
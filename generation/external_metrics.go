@@ -0,0 +1,196 @@
+package generation
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// ExternalMetricFamily describes metrics that don't appear in the source, e.g. from gRPC interceptors, HTTP middleware
+// or the Go runtime collector, for them to be shown and alerted on like discovered metrics
+type ExternalMetricFamily struct {
+	Name         string            // Full metric name
+	Type         string            // counter, gauge, summary, timer or histogram
+	LabelFilters map[string]string // Label values to select
+	Labels       []string          // Labels to break the panel down by
+	Title        string            // Template for the panel title, given .Name, .Type and .LabelFilters. Defaults to the name.
+	Panel        string            // Panel kind, defaults to rate for counters, overtime for gauges, quantiles for summaries and timers, heatmap for histograms
+	Alert        *ExternalMetricAlert
+}
+
+// ExternalMetricAlert fires when the family's rate (counters), value (gauges) or p99 (the rest) exceeds the threshold
+type ExternalMetricAlert struct {
+	Name        string
+	Threshold   string
+	TimeRange   string // Defaults to 5m
+	Duration    string // Defaults to 5m
+	Severity    string
+	Team        string
+	Summary     string
+	Description string
+	RunbookUrl  string
+}
+
+var externalPanelKinds = map[string]func(dg *DashboardGenerator, m *metric) *Panel{
+	"cumulative": (*DashboardGenerator).counterCumulativePanel,
+	"rate":       (*DashboardGenerator).counterRatePanel,
+	"current":    (*DashboardGenerator).gaugeCurrentPanel,
+	"overtime":   (*DashboardGenerator).gaugeOverTimePanel,
+	"breakdown":  (*DashboardGenerator).gaugeBreakdownPanel,
+	"quantiles":  (*DashboardGenerator).summaryTimerPanel,
+	"heatmap":    (*DashboardGenerator).histogramPanel,
+}
+
+var defaultExternalPanelKinds = map[string]string{
+	"counter":   "rate",
+	"gauge":     "overtime",
+	"summary":   "quantiles",
+	"timer":     "quantiles",
+	"histogram": "heatmap",
+}
+
+// jsonRpcFamily is what each of the older `ExternalMetricNames` stands for
+func jsonRpcFamily(metricPrefix string, method string) ExternalMetricFamily {
+	return ExternalMetricFamily{
+		Name:         metricPrefix + "jsonrpc2_server",
+		Type:         "timer",
+		LabelFilters: map[string]string{"method": method},
+		Title:        "JRPC: {{.LabelFilters.method}}",
+	}
+}
+
+// externalMetric turns the family into a metric for the dashboard, with the panel kind it's to be shown as
+func (f ExternalMetricFamily) externalMetric() (*metric, error) {
+	panelKind := f.Panel
+	if panelKind == "" {
+		panelKind = defaultExternalPanelKinds[f.Type]
+	}
+
+	if _, ok := defaultExternalPanelKinds[f.Type]; !ok {
+		return nil, fmt.Errorf("external metric %s has unsupported type %q", f.Name, f.Type)
+	}
+	if _, ok := externalPanelKinds[panelKind]; !ok {
+		return nil, fmt.Errorf("external metric %s has unsupported panel %q", f.Name, panelKind)
+	}
+
+	title, err := f.title()
+	if err != nil {
+		return nil, err
+	}
+
+	m := &metric{
+		FullMetricName:   f.Name,
+		MetricType:       f.Type,
+		LabelNames:       f.Labels,
+		PanelTitle:       title,
+		externalPanel:    panelKind,
+		ExtraLabelFilter: f.filter(),
+	}
+
+	if f.Type == "timer" {
+		m.metricCall = "Timer"
+	}
+
+	labels := f.Labels
+	if f.Type == "summary" || f.Type == "timer" {
+		labels = append(append([]string{}, labels...), "quantile")
+	}
+	if len(labels) > 0 {
+		m.MetricLabels = fmt.Sprintf(" by (%s)", strings.Join(labels, ","))
+	}
+
+	return m, nil
+}
+
+func (f ExternalMetricFamily) title() (string, error) {
+	if f.Title == "" {
+		return f.Name, nil
+	}
+
+	tmpl, err := template.New(f.Name).Option("missingkey=zero").Parse(f.Title)
+	if err != nil {
+		return "", fmt.Errorf("bad title for external metric %s: %v", f.Name, err)
+	}
+
+	buf := bytes.Buffer{}
+	if err := tmpl.Execute(&buf, f); err != nil {
+		return "", fmt.Errorf("bad title for external metric %s: %v", f.Name, err)
+	}
+	return buf.String(), nil
+}
+
+// filter renders the label filters in name order, in the same `label="value",` form as other fixed filters
+func (f ExternalMetricFamily) filter() string {
+	names := make([]string, 0, len(f.LabelFilters))
+	for k := range f.LabelFilters {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	filter := ""
+	for _, each := range names {
+		filter += fmt.Sprintf(`%s="%s",`, each, f.LabelFilters[each])
+	}
+	return filter
+}
+
+// series names the family's metric plus its filters, for linking alerts to panels
+func (f ExternalMetricFamily) series() string {
+	return (&metric{FullMetricName: f.Name, ExtraLabelFilter: f.filter()}).series()
+}
+
+func (dg *DashboardGenerator) externalMetrics(externalMetricNames []string) ([]*metric, error) {
+	families := append([]ExternalMetricFamily{}, dg.ExternalMetrics...)
+	for _, each := range externalMetricNames {
+		families = append(families, jsonRpcFamily(dg.currentMetricPrefix, each))
+	}
+
+	var metrics []*metric
+	for _, each := range families {
+		m, err := each.externalMetric()
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, nil
+}
+
+// addExternalAlertRules adds a rule for each external metric family with an alert
+func (dg *DashboardGenerator) addExternalAlertRules() error {
+	for _, each := range dg.ExternalMetrics {
+		if each.Alert == nil {
+			continue
+		}
+
+		if strings.TrimSpace(each.Alert.Name) == "" {
+			return fmt.Errorf("no name for the alert on external metric %s", each.Name)
+		}
+
+		props := map[string]string{"timeRange": "5m", "duration": "5m"}
+		for k, v := range map[string]string{
+			"name":        each.Alert.Name,
+			"timeRange":   each.Alert.TimeRange,
+			"duration":    each.Alert.Duration,
+			"severity":    each.Alert.Severity,
+			"team":        each.Alert.Team,
+			"summary":     each.Alert.Summary,
+			"description": each.Alert.Description,
+			"runbook_url": each.Alert.RunbookUrl,
+		} {
+			if v != "" {
+				props[k] = v
+			}
+		}
+
+		rule := ExternalMetricAlertRule{props: props, family: each}
+		if _, err := rule.alertRuleThreshold(); err != nil {
+			return err
+		}
+
+		dg.alertRules = append(dg.alertRules, rule)
+	}
+	return nil
+}
@@ -1,15 +1,12 @@
 package generation
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
 	"go/ast"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
-	"text/template"
 
 	"golang.org/x/tools/go/ast/inspector"
 	"golang.org/x/tools/go/packages"
@@ -23,6 +20,20 @@ type DashboardGenerator struct {
 	DefaultMetricsPrefix string
 	DashboardUid         string
 	DashboardTitle       string
+	SchemaVersion        int    // Grafana dashboard schema to target, defaults to DefaultSchemaVersion
+	DatasourceUid        string // Prometheus datasource for dashboard panels, defaults to DefaultGrafanaDatasourceUid
+	GrafanaUrl           string // Base URL for links between alerts and panels
+	DashboardTemplate    string // Path to a template to render the dashboard with, instead of the built-in JSON
+	MergeDashboard       bool   // Update the generated panels in any existing dashboard, rather than replacing it
+	REDRow               bool   // Start with the request rate, error ratio and p99 latency
+	ExternalMetrics      []ExternalMetricFamily
+	Runtime              RuntimeOptions
+	Layout               LayoutOptions
+	Dashboards           DashboardSplitOptions
+	DashboardResource    DashboardResourceOptions
+	Metadata             DashboardMetadataOptions
+	DataLinks            DataLinkOptions
+	Variables            VariableOptions
 
 	rawMetricPrefix     string
 	currentMetricPrefix string
@@ -32,10 +43,15 @@ type DashboardGenerator struct {
 	foundMetricsObject       bool
 	numPrefixesConfigured    int
 	metricsIntercepted       map[string]bool
+	panelIds                 map[string]int // first panel showing each full metric name, as last rendered
+	usedPanelIds             map[int]bool
+	panelGroups              []panelGroup
+	dashboardGroups          []panelGroup
+	redMetrics               *redMetrics
+	panelDashboardUids       map[string]string // by full metric name, where the first panel isn't on the main dashboard
+	metricUnits              map[string]string // annotated units, by metric name
 }
 
-var globalIncrementingPanelId int
-
 func (dg *DashboardGenerator) DiscoverMetrics(loadedPkgs []*packages.Package) ([]*metric, error) {
 	nodeFilter := []ast.Node{(*ast.CommentGroup)(nil), (*ast.CompositeLit)(nil), (*ast.CallExpr)(nil)}
 
@@ -48,6 +64,10 @@ func (dg *DashboardGenerator) DiscoverMetrics(loadedPkgs []*packages.Package) ([
 	dg.caseSensitiveMetricNames = false
 	dg.foundMetricsObject = false
 	dg.numPrefixesConfigured = 0
+	dg.panelGroups = nil
+	dg.dashboardGroups = nil
+	dg.redMetrics = nil
+	dg.metricUnits = make(map[string]string)
 
 	var err error
 
@@ -58,6 +78,8 @@ func (dg *DashboardGenerator) DiscoverMetrics(loadedPkgs []*packages.Package) ([
 			switch stmt := node.(type) {
 			case *ast.CommentGroup:
 				err = dg.processAlertAnnotations(stmt)
+				dg.processDashboardAnnotations(stmt)
+				dg.processMetricAnnotations(stmt)
 
 			case *ast.CompositeLit:
 				// Discover... metricsOpts := promApi.MetricOpts{MetricNamePrefix: serviceName,} \n metrics := promApi.NewMetrics(metricsOpts)
@@ -103,7 +125,7 @@ func (dg *DashboardGenerator) DiscoverMetrics(loadedPkgs []*packages.Package) ([
 						if subExprTypeName != nil && strings.Contains(subExprTypeName.String(), PromenadePkg) {
 
 							metricName := obtainConstantValue(eachPkg, stmt.Args[0], func(value interface{}) string {
-								fmt.Println("Ignore unexpected type: %v", value)
+								fmt.Printf("Ignore unexpected type: %v\n", value)
 								return "" // unused
 							})
 
@@ -126,6 +148,13 @@ func (dg *DashboardGenerator) DiscoverMetrics(loadedPkgs []*packages.Package) ([
 		log.Fatalf("ERROR: No Metrics found")
 	}
 
+	if err := dg.addExternalAlertRules(); err != nil {
+		return metrics, err
+	}
+	if err := dg.addRuntimeAlertRules(); err != nil {
+		return metrics, err
+	}
+
 	if len(metrics) < 1 {
 		log.Printf("No Promenade metrics found")
 		return metrics, nil
@@ -172,75 +201,179 @@ func (dg *DashboardGenerator) DiscoverMetrics(loadedPkgs []*packages.Package) ([
 }
 
 func (dg *DashboardGenerator) GenerateAlertRules(filePath string, options OutputOptions) (AlertMetrics, error) {
+	// Link to the dashboard, if one was generated
+	if options.Dashboard.DashboardUid == "" && dg.panelIds != nil {
+		options.Dashboard = DashboardLinkOptions{GrafanaUrl: dg.GrafanaUrl, DashboardUid: dg.dashboardUid(), PanelIds: dg.panelIds, PanelDashboardUids: dg.panelDashboardUids}
+	}
+
+	if options.AlertRuleFormat == GrafanaAlertingFormat {
+		if options.GrafanaAlerting.DashboardUid == "" {
+			options.GrafanaAlerting.DashboardUid = dg.dashboardUid()
+		}
+		if options.GrafanaAlerting.PanelIds == nil {
+			options.GrafanaAlerting.PanelIds = dg.panelIds
+			options.GrafanaAlerting.PanelDashboardUids = dg.panelDashboardUids
+		}
+	}
+
 	return dg.RuleGenerator.postProcess(filePath, dg.currentMetricPrefix, dg.numPrefixesConfigured > 1, dg.currentMetricPrefix, dg.metricsIntercepted, options)
 }
 
 func (dg *DashboardGenerator) GenerateGrafanaDashboard(destFilePath string, metrics []*metric, dashboardTags []string, externalMetricNames []string) error {
-	tmpl, err := template.New("default").Funcs(template.FuncMap{
-
-		"incrementingPanelId": func() int {
-			globalIncrementingPanelId++
-			return globalIncrementingPanelId
-		},
+	if err := os.MkdirAll(filepath.Dir(destFilePath), os.ModePerm); err != nil {
+		log.Fatalf("Output directory creation failed: %s", err)
+	}
 
-		"panelColumn": func() int {
-			return (globalIncrementingPanelId % 2) * 12 // Switch from left to right, 2 abreast
-		},
-	}).Parse(DefaultDashboardTemplate)
+	title := dg.DashboardTitle
+	if title == "" {
+		title = fmt.Sprintf("%s Visualised Metrics", normaliseAndLowercaseName(dg.displayStringOrDefault(dg.rawMetricPrefix)))
+	}
 
+	externalMetrics, err := dg.externalMetrics(externalMetricNames)
 	if err != nil {
-		log.Fatalf("Template parse failed: %s", err)
+		return err
 	}
 
-	if err := os.MkdirAll(filepath.Dir(destFilePath), os.ModePerm); err != nil {
-		log.Fatalf("Output directory creation failed: %s", err)
+	if err := dg.checkDashboardResourceFormat(); err != nil {
+		return err
 	}
 
-	outputFile, err := os.Create(destFilePath)
+	parts, err := dg.dashboardParts(metrics, externalMetrics)
 	if err != nil {
-		log.Fatalf("Output file creation failed: %s", err)
+		return err
 	}
-	defer outputFile.Close()
 
-	fmt.Println("Writing dashboard to", FriendlyFileName(destFilePath))
+	// Alerts link to the first panel for their metric, preferring the main dashboard
+	panelIds := make(map[string]int)
+	panelDashboardUids := make(map[string]string)
+
+	for _, each := range parts {
+		data := dashboardData{
+			Metrics:         each.metrics,
+			ExternalMetrics: each.externalMetrics,
+			Title:           each.title(title),
+			Id:              dg.partUid(each),
+			Overview:        each.overview,
+			DashboardTags:   dashboardTags,
+			Part:            each.name,
+		}
 
-	uid := dg.DashboardUid
-	if uid == "" {
-		uid = dg.displayStringOrDefault(dg.currentMetricPrefix) + "generated"
+		if each.name == "" && dg.REDRow {
+			data.REDSource = metrics
+		}
+		data.Runtime = each.name == "" && dg.Runtime.Row
+
+		if dg.MergeDashboard {
+			if data.Existing, err = dg.existingDashboard(partPath(destFilePath, each)); err != nil {
+				return err
+			}
+		}
+
+		dashboard, err := dg.buildDashboard(&data)
+		if err != nil {
+			return err
+		}
+
+		if len(parts) > 1 {
+			dg.linkDashboards(dashboard)
+		}
+
+		// After writing, as a template decides its own panels
+		if err := dg.writeDashboard(partPath(destFilePath, each), dashboard, &data); err != nil {
+			return err
+		}
+
+		for name, id := range dg.panelIds {
+			if _, ok := panelIds[name]; !ok {
+				panelIds[name] = id
+				if each.name != "" {
+					panelDashboardUids[name] = dashboard.Uid
+				}
+			}
+		}
 	}
-	uid = truncateText(uid, 40)
 
-	title := dg.DashboardTitle
-	if title == "" {
-		title = fmt.Sprintf("%s Visualised Metrics", normaliseAndLowercaseName(dg.displayStringOrDefault(dg.rawMetricPrefix)))
+	dg.panelIds = panelIds
+	dg.panelDashboardUids = panelDashboardUids
+
+	// Dashboards in resources are provisioned by the sidecar or operator instead
+	if (len(parts) > 1 || dg.Dashboards.Folder != "") && dg.DashboardResource.format() == DashboardJsonFormat {
+		return dg.writeDashboardProvisioning(destFilePath)
+	}
+	return nil
+}
+
+// existingDashboard reads the dashboard JSON to merge into, if there is any
+func (dg *DashboardGenerator) existingDashboard(destFilePath string) ([]byte, error) {
+	existing, err := os.ReadFile(destFilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not read existing dashboard: %v", err)
 	}
 
-	data := dashboardData{
-		Metrics: metrics,
-		Title:   title,
-		Id:      uid,
+	if len(existing) == 0 {
+		return nil, nil
 	}
+	return dg.unwrapDashboard(existing)
+}
+
+func (dg *DashboardGenerator) writeDashboard(destFilePath string, dashboard *Dashboard, data *dashboardData) error {
+	existing := data.Existing
 
-	for _, each := range externalMetricNames {
-		data.ExternalTimers = append(data.ExternalTimers, &metric{
-			FullMetricName:   dg.currentMetricPrefix + "jsonrpc2_server",
-			MetricLabels:     " by (quantile)",
-			ExtraLabelFilter: fmt.Sprintf(`method=\"%s\",`, each),
-			PanelTitle:       fmt.Sprintf(`JRPC: %s`, each),
+	var output []byte
+	var err error
+	if dg.DashboardTemplate != "" {
+		output, err = dg.renderDashboardTemplate(dg.DashboardTemplate, &DashboardTemplateData{
+			Title:           dashboard.Title,
+			Uid:             dashboard.Uid,
+			Tags:            dashboard.Tags,
+			SchemaVersion:   dashboard.SchemaVersion,
+			Metrics:         data.Metrics,
+			ExternalMetrics: data.ExternalMetrics,
+			Dashboard:       dashboard,
 		})
+		if err != nil {
+			return err
+		}
+	} else if output, err = MarshalDashboard(dashboard); err != nil {
+		log.Fatalf("Dashboard marshalling failed: %s", err)
 	}
 
-	rawJsonBuf := bytes.Buffer{}
-	if tErr := tmpl.Execute(&rawJsonBuf, &data); tErr != nil {
-		log.Fatalf("template execution: %s", tErr)
+	if len(existing) > 0 {
+		var report DashboardMergeReport
+		if output, report, err = mergeDashboard(existing, output); err != nil {
+			return fmt.Errorf("could not merge into %s: %v", FriendlyFileName(destFilePath), err)
+		}
+
+		fmt.Println("Merged dashboard panels:", report)
+		for _, each := range report.Conflicts {
+			fmt.Println("Conflict:", each)
+		}
+	}
+
+	warnings, err := validateDashboard(FriendlyFileName(destFilePath), output, dg.schemaVersion())
+	if err != nil {
+		return err
+	}
+	for _, each := range warnings {
+		fmt.Printf("[WARNING] dashboard %s: %s\n", FriendlyFileName(destFilePath), each)
 	}
 
-	prettyBuf := bytes.Buffer{}
-	if e := json.Indent(&prettyBuf, rawJsonBuf.Bytes(), "", "\t"); e != nil {
-		log.Fatalf("JSON prettifying failed: %s", e)
+	if output, err = dg.wrapDashboard(destFilePath, data.Part, output); err != nil {
+		return err
 	}
 
-	_, err = outputFile.Write(prettyBuf.Bytes())
+	outputFile, err := os.Create(destFilePath)
+	if err != nil {
+		log.Fatalf("Output file creation failed: %s", err)
+	}
+	defer outputFile.Close()
+
+	fmt.Println("Writing dashboard to", FriendlyFileName(destFilePath))
+
+	_, err = outputFile.Write(output)
 	if err != nil {
 		log.Fatalf("Dashboard write failed: %s", err)
 	}
@@ -248,6 +381,14 @@ func (dg *DashboardGenerator) GenerateGrafanaDashboard(destFilePath string, metr
 	return nil
 }
 
+func (dg *DashboardGenerator) dashboardUid() string {
+	uid := dg.DashboardUid
+	if uid == "" {
+		uid = dg.displayStringOrDefault(dg.currentMetricPrefix) + "generated"
+	}
+	return truncateText(uid, 40)
+}
+
 func (dg *DashboardGenerator) displayStringOrDefault(desired string) string {
 	if desired != "" {
 		return desired
@@ -266,12 +407,13 @@ func (dg *DashboardGenerator) interceptMetric(pkg *packages.Package, metricCall
 
 	metricType := ""
 	metricLabelString := ""
+	var labelNames []string
 
 	if strings.HasPrefix(metricCall, "Counter") {
 		if metricCall == "CounterWithLabels" {
 
 			multipleLabels := metricCallArgs[1].(*ast.CompositeLit).Elts
-			labelNames := make([]string, len(multipleLabels))
+			labelNames = make([]string, len(multipleLabels))
 
 			for i, entry := range multipleLabels {
 				labelNames[i] = stripQuotes(entry.(*ast.BasicLit).Value)
@@ -285,6 +427,7 @@ func (dg *DashboardGenerator) interceptMetric(pkg *packages.Package, metricCall
 				log.Fatalf("Could not obtain counter label: %v", value)
 				return "" // unused
 			})
+			labelNames = []string{singleLabel}
 			metricLabelString = fmt.Sprintf(" by (%s)", singleLabel)
 
 			metricType = "counter"
@@ -303,6 +446,7 @@ func (dg *DashboardGenerator) interceptMetric(pkg *packages.Package, metricCall
 
 		if metricCall == "TimerWithLabel" {
 			singleLabel := stripQuotes(metricCallArgs[1].(*ast.BasicLit).Value)
+			labelNames = []string{singleLabel}
 			metricLabelString = fmt.Sprintf(" by (%s,quantile)", singleLabel)
 		} else {
 			metricLabelString = " by (quantile)"
@@ -314,7 +458,7 @@ func (dg *DashboardGenerator) interceptMetric(pkg *packages.Package, metricCall
 		if metricCall == "SummaryWithLabels" {
 
 			multipleLabels := metricCallArgs[1].(*ast.CompositeLit).Elts
-			labelNames := make([]string, len(multipleLabels))
+			labelNames = make([]string, len(multipleLabels))
 
 			for i, entry := range multipleLabels {
 				labelNames[i] = stripQuotes(entry.(*ast.BasicLit).Value)
@@ -324,6 +468,7 @@ func (dg *DashboardGenerator) interceptMetric(pkg *packages.Package, metricCall
 		} else if metricCall == "SummaryWithLabel" {
 
 			singleLabel := stripQuotes(metricCallArgs[1].(*ast.BasicLit).Value)
+			labelNames = []string{singleLabel}
 			metricLabelString = fmt.Sprintf(" by (%s,quantile)", singleLabel)
 		} else {
 			metricLabelString = " by (quantile)"
@@ -332,7 +477,7 @@ func (dg *DashboardGenerator) interceptMetric(pkg *packages.Package, metricCall
 		return nil
 	}
 
-	return &metric{metricCall: metricCall, normalisedMetricName: normalisedMetricName, PanelTitle: metricName, MetricType: metricType, MetricLabels: metricLabelString}
+	return &metric{metricCall: metricCall, normalisedMetricName: normalisedMetricName, PanelTitle: metricName, MetricType: metricType, MetricLabels: metricLabelString, LabelNames: labelNames, PackagePath: pkg.PkgPath}
 }
 
 const BadPrefix = "__bad__"
@@ -388,12 +533,17 @@ func (dg *DashboardGenerator) handleDiscoveredPrefix(separator string) {
 }
 
 type dashboardData struct {
-	Metrics        []*metric
-	ExternalTimers []*metric
+	Metrics         []*metric
+	ExternalMetrics []*metric
 
 	Title         string
 	Id            string
+	Part          string // Blank for the main dashboard
 	DashboardTags []string
+	Overview      bool      // Just the first panel for each metric, and all the errors
+	REDSource     []*metric // Metrics to pick the RED row's from, if there is to be one
+	Runtime       bool      // End with the Go runtime and process panels
+	Existing      []byte    // The dashboard JSON being merged into, if any
 }
 
 type metric struct {
@@ -403,14 +553,34 @@ type metric struct {
 	MetricsPrefix  string
 	MetricType     string
 	MetricLabels   string
+	LabelNames     []string // Excluding any `quantile`
 	FullMetricName string
 	PanelTitle     string
+	PackagePath    string
 
 	ExtraLabelFilter string
+
+	externalPanel string // The kind of panel for an external metric
 }
 
 var normalizer = strings.NewReplacer(".", "_", "-", "_", "#", "_", " ", "_")
 
+// series names the metric plus any fixed filter
+func (m *metric) series() string {
+	if m.ExtraLabelFilter != "" {
+		return m.FullMetricName + "{" + m.ExtraLabelFilter + "}"
+	}
+	return m.FullMetricName
+}
+
+// panelKey identifies one kind of panel for the metric, e.g. its rate
+func (m *metric) panelKey(kind string) string {
+	if m.ExtraLabelFilter != "" {
+		return m.FullMetricName + "{" + m.ExtraLabelFilter + "}/" + kind
+	}
+	return m.FullMetricName + "/" + kind
+}
+
 func normaliseAndLowercaseName(name string) string {
 	return strings.ToLower(normalizer.Replace(name))
 }
@@ -1,9 +1,13 @@
 package generation
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -11,6 +15,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"golang.org/x/tools/go/packages"
+	"gopkg.in/yaml.v2"
 )
 
 var scanConf = packages.Config{
@@ -125,13 +130,147 @@ func TestAlertRuleGeneration(t *testing.T) {
 	assert.Equal(t, strings.TrimSpace(string(bytes)), strings.TrimSpace(expectedOutput))
 }
 
-func TestGrafanaDashboardGeneration(t *testing.T) {
+var expectedPrometheusRuleOutput = `
+apiVersion: monitoring.coreos.com/v1
+kind: PrometheusRule
+metadata:
+  name: application-rules
+  namespace: monitoring
+  labels:
+    release: kube-prometheus-stack
+spec:
+  groups:
+  - name: Application auto-generated alerts
+    rules:
+    - alert: ApplicationCalcError
+      expr: sum(rate(prefix_errors{error_type='e'}[1m])) > 0
+      for: 10s
+      labels:
+        severity: pager
+        team: myTeam
+      annotations:
+        description: A calculation failed unexpectedly
+        summary: Calculation error
+`
+
+func TestPrometheusRuleResourceGeneration(t *testing.T) {
+	loadedPkgs, err := packages.Load(&scanConf, "")
+	assert.NoError(t, err)
+
+	generator := &DashboardGenerator{}
+	_, _ = generator.DiscoverMetrics(loadedPkgs)
+
+	tempFile, err := os.CreateTemp("", "x*.yaml")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//goland:noinspection GoUnhandledErrorResult
+	defer os.Remove(tempFile.Name())
+
+	ruleOptions := PrometheusRuleOptions{Name: "application-rules", Namespace: "monitoring", Labels: []string{"release=kube-prometheus-stack"}}
+
+	_, err = generator.GenerateAlertRules(tempFile.Name(), OutputOptions{AlertRuleFormat: PrometheusRuleResourceFormat, PrometheusRule: ruleOptions})
+	assert.NoError(t, err)
+
+	bytes, _ := os.ReadFile(tempFile.Name())
+	assert.True(t, strings.HasPrefix(string(bytes), strings.TrimSpace(expectedPrometheusRuleOutput)))
+
+	ruleOptions.HelmTemplate = true
+
+	_, err = generator.GenerateAlertRules(tempFile.Name(), OutputOptions{AlertRuleFormat: PrometheusRuleResourceFormat, PrometheusRule: ruleOptions})
+	assert.NoError(t, err)
+
+	bytes, _ = os.ReadFile(tempFile.Name())
+	data := string(bytes)
+
+	assert.Contains(t, data, `name: {{ .Values.prometheusRule.name | default "application-rules" }}`)
+	assert.Contains(t, data, `namespace: {{ .Values.prometheusRule.namespace | default "monitoring" }}`)
+	assert.Contains(t, data, `    release: "kube-prometheus-stack"`)
+	assert.Contains(t, data, `{{- with .Values.prometheusRule.labels }}`)
+	assert.Contains(t, data, `      expr: sum(rate(prefix_errors{error_type='e'}[1m])) > 0`)
+}
+
+func TestGrafanaAlertingGeneration(t *testing.T) {
 	loadedPkgs, err := packages.Load(&scanConf, "")
 	assert.NoError(t, err)
 
 	generator := &DashboardGenerator{}
 	metrics, _ := generator.DiscoverMetrics(loadedPkgs)
 
+	dashboardFile, err := os.CreateTemp("", "dash*.json")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//goland:noinspection GoUnhandledErrorResult
+	defer os.Remove(dashboardFile.Name())
+
+	tempFile, err := os.CreateTemp("", "x*.yaml")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//goland:noinspection GoUnhandledErrorResult
+	defer os.Remove(tempFile.Name())
+
+	assert.NoError(t, generator.GenerateGrafanaDashboard(dashboardFile.Name(), metrics, nil, nil))
+
+	alertMetrics, err := generator.GenerateAlertRules(tempFile.Name(), OutputOptions{AlertRuleFormat: GrafanaAlertingFormat, GrafanaAlerting: GrafanaAlertingOptions{Folder: "Alerts", DatasourceUid: "prom-uid"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, alertMetrics.Count)
+
+	var provisioning GrafanaAlertingProvisioning
+	bytes, _ := os.ReadFile(tempFile.Name())
+	assert.NoError(t, yaml.Unmarshal(bytes, &provisioning))
+
+	assert.Equal(t, 1, provisioning.ApiVersion)
+	assert.Equal(t, "Alerts", provisioning.Groups[0].Folder)
+	assert.Equal(t, "Application auto-generated alerts", provisioning.Groups[0].Name)
+
+	rule := provisioning.Groups[0].Rules[1]
+	assert.Equal(t, "applicationcalcproblems", rule.Uid)
+	assert.Equal(t, "ApplicationCalcProblems", rule.Title)
+	assert.Equal(t, "5m", rule.For)
+	assert.Equal(t, "B", rule.Condition)
+	assert.Equal(t, "prom-uid", rule.Data[0].DatasourceUid)
+	assert.Equal(t, "sum(rate(prefix_errors{error_type='e'}[10m]))", rule.Data[0].Model["expr"])
+	assert.Equal(t, "__expr__", rule.Data[1].DatasourceUid)
+	assert.Equal(t, map[string]string{"severity": "warning", "team": "myTeam"}, rule.Labels)
+
+	errorsPanelId := generator.panelIds["prefix_errors{error_type='e'}"]
+	assert.NotZero(t, errorsPanelId)
+	assert.Equal(t, "prefix_generated", rule.DashboardUid)
+	assert.Equal(t, errorsPanelId, rule.PanelId)
+	assert.Equal(t, "prefix_generated", rule.Annotations["__dashboardUid__"])
+	assert.Equal(t, fmt.Sprint(errorsPanelId), rule.Annotations["__panelId__"])
+}
+
+func TestGrafanaRuleUids(t *testing.T) {
+	first := grafanaRuleUid("PaymentsElevatedErrorRateForRefundReconciliationTimeout")
+	second := grafanaRuleUid("PaymentsElevatedErrorRateForRefundReconciliationFailure")
+	assert.Equal(t, "paymentselevatederrorrateforref-", first[:32])
+	assert.NotEqual(t, first, second)
+	assert.LessOrEqual(t, len(first), 40)
+	assert.LessOrEqual(t, len(second), 40)
+	assert.Equal(t, "applicationcalcproblems", grafanaRuleUid("ApplicationCalcProblems"))
+
+	// Different names, but the same once made safe
+	rg := RuleGenerator{alertRules: []AlertRule{
+		RuntimeAlertRule{props: map[string]string{"name": "calc.slow", "team": "t", "severity": "s", "summary": "Slow"}, query: "up", threshold: "1"},
+		RuntimeAlertRule{props: map[string]string{"name": "calc-slow", "team": "t", "severity": "s", "summary": "Slow"}, query: "up", threshold: "1"},
+	}}
+	_, err := rg.postProcess(filepath.Join(t.TempDir(), "rules.yaml"), "", false, "", nil, OutputOptions{AlertRuleFormat: GrafanaAlertingFormat})
+	assert.EqualError(t, err, "alerts ApplicationCalc.Slow and ApplicationCalc-Slow have the same Grafana rule UID applicationcalc-slow")
+}
+
+func TestGrafanaDashboardGeneration(t *testing.T) {
+	loadedPkgs, err := packages.Load(&scanConf, "")
+	assert.NoError(t, err)
+
+	generator := &DashboardGenerator{Layout: LayoutOptions{Rows: NoRows}}
+	metrics, _ := generator.DiscoverMetrics(loadedPkgs)
+
 	tempFile, err := os.CreateTemp("", "dash*.json")
 	if err != nil {
 		log.Fatal(err)
@@ -149,9 +288,1206 @@ func TestGrafanaDashboardGeneration(t *testing.T) {
 	// data, _ := json.Marshal(bytes)
 
 	// FIXME Improve
-	assert.Contains(t, data, `"expr": "sum(rate(prefix_places[15m])) by (city)`)
-	assert.Contains(t, data, `"expr": "sum(prefix_animals) by (type,breed)"`)
-	assert.Contains(t, data, `"expr": "avg(prefix_t{quantile=~\"0.5|0.75|0.9|0.99\"}) by (quantile)"`)
+	selector := `namespace=~\"$namespace\",job=~\"$job\",instance=~\"$instance\"`
+	assert.Contains(t, data, `"expr": "sum(rate(prefix_places{`+selector+`}[15m])) by (city)`)
+	assert.Contains(t, data, `"expr": "sum(increase(prefix_animals{`+selector+`}[$__range])) by (type,breed)"`)
+	assert.Contains(t, data, `"expr": "sum(prefix_g{`+selector+`})"`)
+	assert.NotContains(t, data, `rate(prefix_g`)
+	assert.Contains(t, data, `"expr": "avg(prefix_t{`+selector+`,quantile=~\"0.5|0.75|0.9|0.99\"}) by (quantile)"`)
+
+	var dashboard Dashboard
+	assert.NoError(t, json.Unmarshal(bytes, &dashboard))
+	assert.Equal(t, DefaultSchemaVersion, dashboard.SchemaVersion)
+	assert.Equal(t, []string{"stat", "timeseries", "bargauge", "timeseries", "bargauge", "timeseries", "timeseries", "table", "timeseries", "gauge", "timeseries", "heatmap", "heatmap", "timeseries", "timeseries"}, panelTypes(&dashboard))
+	assert.Equal(t, `sum(rate(prefix_h_bucket{namespace=~"$namespace",job=~"$job",instance=~"$instance"}[$__rate_interval])) by (le)`, dashboard.Panels[11].Targets[0].Expr)
+	assert.Equal(t, "{{type}}, {{breed}}", dashboard.Panels[4].Targets[0].LegendFormat)
+	assert.Equal(t, "short", dashboard.Panels[4].FieldConfig.Defaults.Unit)
+
+	generator.SchemaVersion = LegacySchemaVersion
+
+	err = generator.GenerateGrafanaDashboard(tempFile.Name(), metrics, nil, nil)
+	assert.NoError(t, err)
+
+	bytes, _ = os.ReadFile(tempFile.Name())
+	assert.NoError(t, json.Unmarshal(bytes, &dashboard))
+	assert.Equal(t, LegacySchemaVersion, dashboard.SchemaVersion)
+	assert.Equal(t, []string{"graph", "graph", "graph", "graph", "graph", "graph", "graph", "graph", "graph", "graph", "graph", "graph"}, panelTypes(&dashboard))
+}
+
+func TestDashboardLayout(t *testing.T) {
+	loadedPkgs, err := packages.Load(&scanConf, "")
+	assert.NoError(t, err)
+
+	generator := &DashboardGenerator{}
+	metrics, _ := generator.DiscoverMetrics(loadedPkgs)
+
+	dashboard, err := generator.buildDashboard(&dashboardData{Metrics: metrics})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"row", "timeseries", "table", "timeseries", "row", "timeseries", "row", "timeseries", "row", "heatmap", "heatmap", "row", "stat", "timeseries", "bargauge", "timeseries", "bargauge", "timeseries", "row", "gauge", "timeseries"}, panelTypes(dashboard))
+	assert.Equal(t, []string{"Errors", "Timers", "Summaries", "Histograms", "Counters", "Gauges"}, rowTitles(dashboard))
+
+	// Each row starts a new line, and panels wrap once the 24 columns are used up
+	assert.Equal(t, GridPos{H: 1, W: 24, X: 0, Y: 0}, dashboard.Panels[0].GridPos)
+	assert.Equal(t, GridPos{H: 9, W: 12, X: 0, Y: 1}, dashboard.Panels[1].GridPos)
+	assert.Equal(t, GridPos{H: 9, W: 12, X: 12, Y: 1}, dashboard.Panels[2].GridPos)
+	assert.Equal(t, GridPos{H: 9, W: 12, X: 0, Y: 10}, dashboard.Panels[3].GridPos)
+	assert.Equal(t, GridPos{H: 1, W: 24, X: 0, Y: 19}, dashboard.Panels[4].GridPos)
+	assert.Equal(t, GridPos{H: 9, W: 12, X: 0, Y: 40}, dashboard.Panels[9].GridPos)
+	assert.Equal(t, GridPos{H: 9, W: 12, X: 12, Y: 40}, dashboard.Panels[10].GridPos)
+	assert.Equal(t, GridPos{H: 6, W: 6, X: 0, Y: 50}, dashboard.Panels[12].GridPos)
+	assert.Equal(t, GridPos{H: 9, W: 12, X: 6, Y: 50}, dashboard.Panels[13].GridPos)
+	assert.Equal(t, GridPos{H: 9, W: 12, X: 0, Y: 59}, dashboard.Panels[14].GridPos)
+
+	// Same again
+	again, _ := generator.buildDashboard(&dashboardData{Metrics: metrics})
+	assert.Equal(t, gridPositions(dashboard), gridPositions(again))
+
+	generator.Layout = LayoutOptions{Rows: RowsByPackage, PanelSizes: []string{"stat=12x4", "timeseries = 12x8"}}
+	dashboard, err = generator.buildDashboard(&dashboardData{Metrics: metrics})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"github.com/poblish/boulevard/generation"}, rowTitles(dashboard))
+	assert.Equal(t, GridPos{H: 4, W: 12, X: 0, Y: 1}, dashboard.Panels[1].GridPos)
+	assert.Equal(t, GridPos{H: 8, W: 12, X: 12, Y: 1}, dashboard.Panels[2].GridPos)
+
+	generator.Layout = LayoutOptions{Rows: RowsByAnnotation, CollapseRows: true}
+	dashboard, err = generator.buildDashboard(&dashboardData{Metrics: metrics})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Geography", "Other"}, rowTitles(dashboard))
+	assert.Equal(t, 2, len(dashboard.Panels))
+	assert.True(t, *dashboard.Panels[0].Collapsed)
+	assert.Equal(t, []string{"bargauge", "timeseries", "bargauge", "timeseries"}, panelTypes(&Dashboard{Panels: dashboard.Panels[0].Panels}))
+	assert.Equal(t, GridPos{H: 1, W: 24, X: 0, Y: 19}, dashboard.Panels[1].GridPos)
+
+	generator.Layout = LayoutOptions{PanelSizes: []string{"stat=30x4"}}
+	_, err = generator.buildDashboard(&dashboardData{Metrics: metrics})
+	assert.Error(t, err)
+	assert.Equal(t, "bad panel size stat=30x4, expected WxH with W up to 24", err.Error())
+}
+
+func TestDashboardPanelIdsAreStable(t *testing.T) {
+	loadedPkgs, err := packages.Load(&scanConf, "")
+	assert.NoError(t, err)
+
+	generator := &DashboardGenerator{}
+	metrics, _ := generator.DiscoverMetrics(loadedPkgs)
+
+	first, err := generator.buildDashboard(&dashboardData{Metrics: metrics})
+	assert.NoError(t, err)
+
+	// Same ids on every run, whichever generator is used
+	again, _ := generator.buildDashboard(&dashboardData{Metrics: metrics})
+	assert.Equal(t, panelIds(first), panelIds(again))
+
+	otherGenerator := &DashboardGenerator{}
+	_, _ = otherGenerator.DiscoverMetrics(loadedPkgs)
+	again, _ = otherGenerator.buildDashboard(&dashboardData{Metrics: metrics})
+	assert.Equal(t, panelIds(first), panelIds(again))
+
+	ids := make(map[int]bool)
+	for _, each := range panelIds(first) {
+		assert.False(t, ids[each], "duplicate id %d", each)
+		ids[each] = true
+	}
+
+	// A new metric doesn't renumber the others
+	withNewMetric := append([]*metric{{FullMetricName: "prefix_new", PanelTitle: "new", MetricType: "counter"}}, metrics...)
+	again, _ = generator.buildDashboard(&dashboardData{Metrics: withNewMetric})
+
+	idsByTitle := make(map[string]int)
+	for _, each := range again.Panels {
+		idsByTitle[each.Title] = each.Id
+	}
+	for _, each := range first.Panels {
+		assert.Equal(t, each.Id, idsByTitle[each.Title], each.Title)
+	}
+
+	// Clashes move on to the next free id
+	generator.usedPanelIds = map[int]bool{panelIdForKey("x"): true}
+	clashing := &Panel{}
+	generator.assignPanelId(clashing, "x", nil)
+	assert.Equal(t, panelIdForKey("x")%maxPanelId+1, clashing.Id)
+}
+
+func TestDashboardVariables(t *testing.T) {
+	loadedPkgs, err := packages.Load(&scanConf, "")
+	assert.NoError(t, err)
+
+	generator := &DashboardGenerator{DatasourceUid: "prom-uid", Layout: LayoutOptions{Rows: NoRows}}
+	metrics, _ := generator.DiscoverMetrics(loadedPkgs)
+
+	dashboard, err := generator.buildDashboard(&dashboardData{Metrics: metrics})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"datasource", "namespace", "job", "instance"}, variableNames(dashboard))
+	assert.Equal(t, map[string]string{"text": "prom-uid", "value": "prom-uid"}, dashboard.Templating.List[0].Current)
+	assert.Equal(t, `label_values(up{namespace=~"$namespace",job=~"$job"}, instance)`, dashboard.Templating.List[3].Query)
+	assert.Equal(t, DataSourceRef{Type: "prometheus", Uid: "${datasource}"}, dashboard.Panels[0].Datasource)
+
+	generator.Variables.LabelVariables = true
+
+	dashboard, err = generator.buildDashboard(&dashboardData{Metrics: metrics})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"datasource", "namespace", "job", "instance", "city", "type", "breed"}, variableNames(dashboard))
+	assert.Equal(t, `label_values(prefix_places{namespace=~"$namespace",job=~"$job",instance=~"$instance"}, city)`, dashboard.Templating.List[4].Query)
+	assert.Equal(t, `sum(rate(prefix_places{namespace=~"$namespace",job=~"$job",instance=~"$instance",city=~"$city"}[15m])) by (city)`, dashboard.Panels[3].Targets[0].Expr)
+
+	generator.SchemaVersion = LegacySchemaVersion
+
+	dashboard, err = generator.buildDashboard(&dashboardData{Metrics: metrics})
+	assert.NoError(t, err)
+	assert.Equal(t, "$datasource", dashboard.Templating.List[1].Datasource)
+	assert.Equal(t, "$datasource", dashboard.Panels[0].Datasource)
+}
+
+func TestUnitInference(t *testing.T) {
+	loadedPkgs, err := packages.Load(&scanConf, "")
+	assert.NoError(t, err)
+
+	generator := &DashboardGenerator{}
+	metrics, _ := generator.DiscoverMetrics(loadedPkgs)
+
+	units := make(map[string]string)
+	rateUnits := make(map[string]string)
+	for _, each := range metrics {
+		units[each.PanelTitle] = generator.unit(each)
+		rateUnits[each.PanelTitle] = generator.rateUnit(each)
+	}
+
+	assert.Equal(t, "short", units["c"])
+	assert.Equal(t, "cps", rateUnits["c"])
+	assert.Equal(t, "bytes", units["g"]) // annotated
+	assert.Equal(t, "Bps", rateUnits["g"])
+	assert.Equal(t, "s", units["h"]) // HistogramForResponseTime
+	assert.Equal(t, "short", units["hb"])
+	assert.Equal(t, "short", units["s"])
+	assert.Equal(t, "s", units["t"]) // Timer
+
+	assert.Equal(t, "bytes", generator.unit(&metric{FullMetricName: "prefix_payload_bytes_total"}))
+	assert.Equal(t, "s", generator.unit(&metric{FullMetricName: "prefix_request_duration_seconds"}))
+	assert.Equal(t, "percentunit", generator.unit(&metric{FullMetricName: "prefix_cache_hit_ratio"}))
+	assert.Equal(t, "percentunit", generator.rateUnit(&metric{FullMetricName: "prefix_cpu_seconds_total"}))
+	assert.Equal(t, "suffix: ms/s", generator.rateUnit(&metric{FullMetricName: "prefix_wait_milliseconds_total"}))
+	assert.Equal(t, "suffix: µs/s", generator.rateUnit(&metric{FullMetricName: "prefix_wait_microseconds_total"}))
+	assert.Equal(t, "suffix: ns/s", generator.rateUnit(&metric{FullMetricName: "prefix_wait_nanoseconds_total"}))
+	assert.Equal(t, "watt", generator.rateUnit(&metric{FullMetricName: "prefix_energy_joules_total"}))
+	assert.Equal(t, "suffix: volt/s", generator.rateUnit(&metric{FullMetricName: "prefix_volts_total"}))
+
+	generator.Layout.Rows = NoRows
+	dashboard, err := generator.buildDashboard(&dashboardData{Metrics: metrics})
+	assert.NoError(t, err)
+
+	ratePanel := dashboard.Panels[1]
+	assert.Equal(t, "c (per second)", ratePanel.Title)
+	assert.Equal(t, "cps", ratePanel.FieldConfig.Defaults.Unit)
+	assert.Equal(t, "per second", ratePanel.FieldConfig.Defaults.Custom["axisLabel"])
+}
+
+func TestGaugeAndCounterPanels(t *testing.T) {
+	generator := &DashboardGenerator{Layout: LayoutOptions{Rows: NoRows}}
+	dashboard, err := generator.buildDashboard(&dashboardData{Metrics: []*metric{
+		{FullMetricName: "prefix_queue", PanelTitle: "queue", MetricType: "gauge", MetricLabels: " by (topic)", LabelNames: []string{"topic"}},
+		{FullMetricName: "prefix_sent", PanelTitle: "sent", MetricType: "counter", MetricLabels: " by (topic)", LabelNames: []string{"topic"}},
+	}})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"gauge", "timeseries", "bargauge", "bargauge", "timeseries"}, panelTypes(dashboard))
+	assert.Equal(t, []string{"queue (current)", "queue (over time)", "queue (by topic)", "sent (cumulative)", "sent (per second)"}, panelTitles(dashboard))
+
+	selector := `{namespace=~"$namespace",job=~"$job",instance=~"$instance"}`
+	assert.Equal(t, "sum(prefix_queue"+selector+")", dashboard.Panels[0].Targets[0].Expr)
+	assert.Equal(t, "sum(prefix_queue"+selector+")", dashboard.Panels[1].Targets[0].Expr)
+	assert.Equal(t, []string{"min", "max", "mean"}, dashboard.Panels[1].Options.(map[string]interface{})["legend"].(map[string]interface{})["calcs"])
+	assert.Equal(t, "sum(prefix_queue"+selector+") by (topic)", dashboard.Panels[2].Targets[0].Expr)
+	assert.Equal(t, "{{topic}}", dashboard.Panels[2].Targets[0].LegendFormat)
+	assert.Equal(t, "sum(increase(prefix_sent"+selector+"[$__range])) by (topic)", dashboard.Panels[3].Targets[0].Expr)
+	assert.Equal(t, "sum(rate(prefix_sent"+selector+"[15m])) by (topic)", dashboard.Panels[4].Targets[0].Expr)
+}
+
+func TestErrorPanels(t *testing.T) {
+	loadedPkgs, err := packages.Load(&scanConf, "")
+	assert.NoError(t, err)
+
+	generator := &DashboardGenerator{}
+	metrics, _ := generator.DiscoverMetrics(loadedPkgs)
+
+	dashboard, err := generator.buildDashboard(&dashboardData{Metrics: metrics})
+	assert.NoError(t, err)
+
+	selector := `namespace=~"$namespace",job=~"$job",instance=~"$instance"`
+	assert.Equal(t, []string{"Errors", "Errors by type (per second)", "Top error types", "Errors: e (per second)"}, panelTitles(&Dashboard{Panels: dashboard.Panels[:4]}))
+	assert.Equal(t, "sum(rate(prefix_errors{"+selector+"}[$__rate_interval])) by (error_type)", dashboard.Panels[1].Targets[0].Expr)
+	assert.Equal(t, "topk(10, sum(increase(prefix_errors{"+selector+"}[$__range])) by (error_type))", dashboard.Panels[2].Targets[0].Expr)
+	assert.Equal(t, "table", dashboard.Panels[2].Targets[0].Format)
+
+	alertPanel := dashboard.Panels[3]
+	assert.Equal(t, []Target{
+		{Expr: "sum(rate(prefix_errors{error_type='e'}[1m]))", LegendFormat: "e over 1m", RefId: "A"},
+		{Expr: "sum(rate(prefix_errors{error_type='e'}[10m]))", LegendFormat: "e over 10m", RefId: "B"},
+	}, alertPanel.Targets)
+	assert.Equal(t, []ThresholdStep{{Color: "green"}, {Color: "red", Value: floatPtr(0)}, {Color: "red", Value: floatPtr(1)}}, alertPanel.FieldConfig.Defaults.Thresholds.Steps)
+	assert.Equal(t, map[string]string{"mode": "line"}, alertPanel.FieldConfig.Defaults.Custom["thresholdsStyle"])
+	assert.Equal(t, alertPanel.Id, generator.panelIds["prefix_errors{error_type='e'}"])
+
+	annotations := dashboard.Annotations.List
+	assert.Equal(t, 3, len(annotations))
+	assert.Equal(t, "ApplicationCalcError firing", annotations[1].Name)
+	assert.Equal(t, `ALERTS{alertname="ApplicationCalcError",alertstate="firing"}`, annotations[1].Expr)
+	assert.Equal(t, &AnnotationFilter{Ids: []int{alertPanel.Id}}, annotations[1].Filter)
+	assert.Equal(t, "ApplicationCalcProblems firing", annotations[2].Name)
+}
+
+func TestDashboardTemplate(t *testing.T) {
+	loadedPkgs, err := packages.Load(&scanConf, "")
+	assert.NoError(t, err)
+
+	generator := &DashboardGenerator{DashboardUid: "my-dash"}
+	metrics, _ := generator.DiscoverMetrics(loadedPkgs)
+
+	dashboardFile, err := os.CreateTemp("", "dash*.json")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//goland:noinspection GoUnhandledErrorResult
+	defer os.Remove(dashboardFile.Name())
+
+	generator.DashboardTemplate = writeTemplate(t, `{
+  "title": {{quote .Title}},
+  "uid": {{quote .Uid}},
+  "schemaVersion": {{.SchemaVersion}},
+  "panels": [
+    {"id": {{panelId "custom/row"}}, "type": "row", "title": "Custom", "gridPos": {{gridPos "row"}}},
+    {{- with metric "g"}}
+    {"id": {{panelId "custom/g"}}, "type": "stat", "title": "{{jsonEscape .PanelTitle}} \"now\"", "datasource": {{datasource}}, "gridPos": {{gridPos "stat"}},
+     "fieldConfig": {"defaults": {"unit": {{quote (unit .)}}}},
+     "targets": [{"expr": {{quote (printf "sum(%s%s)" .FullMetricName (selector .))}}, "refId": "A"}]},
+    {{- end}}
+    {"id": {{panelId "custom/g2"}}, "type": "stat", "title": "After {{panelRef "custom/g"}}", "gridPos": {{gridPos "stat"}}},
+    {"id": {{panelId "custom/errors" "prefix_errors{error_type='e'}"}}, "type": "stat", "title": "Calc errors", "gridPos": {{gridPos "stat"}}}
+  ],
+  "generated": {{json (len .Dashboard.Panels)}}
+}`)
+	assert.NoError(t, generator.GenerateGrafanaDashboard(dashboardFile.Name(), metrics, nil, nil))
+
+	var dashboard Dashboard
+	bytes, _ := os.ReadFile(dashboardFile.Name())
+	assert.NoError(t, json.Unmarshal(bytes, &dashboard))
+
+	assert.Equal(t, "my-dash", dashboard.Uid)
+	assert.Equal(t, []string{"row", "stat", "stat", "stat"}, panelTypes(&dashboard))
+	assert.Equal(t, []GridPos{{X: 0, Y: 0, W: 24, H: 1}, {X: 0, Y: 1, W: 6, H: 6}, {X: 6, Y: 1, W: 6, H: 6}, {X: 12, Y: 1, W: 6, H: 6}}, gridPositions(&dashboard))
+
+	panel := dashboard.Panels[1]
+	assert.Equal(t, panelIdForKey("custom/g"), panel.Id)
+	assert.Equal(t, "After "+strconv.Itoa(panel.Id), dashboard.Panels[2].Title)
+	assert.Equal(t, `g "now"`, panel.Title)
+	assert.Equal(t, "bytes", panel.FieldConfig.Defaults.Unit)
+	assert.Equal(t, `sum(prefix_g{namespace=~"$namespace",job=~"$job",instance=~"$instance"})`, panel.Targets[0].Expr)
+
+	// Alerts link to the template's panels, not those it replaced
+	assert.Equal(t, map[string]int{"prefix_errors{error_type='e'}": dashboard.Panels[3].Id}, generator.panelIds)
+
+	rulesPath := filepath.Join(t.TempDir(), "rules.yaml")
+	_, err = generator.GenerateAlertRules(rulesPath, OutputOptions{AlertRuleFormat: GrafanaAlertingFormat, GrafanaAlerting: GrafanaAlertingOptions{Folder: "Alerts", DatasourceUid: "prom-uid"}})
+	assert.NoError(t, err)
+
+	var provisioning GrafanaAlertingProvisioning
+	bytes, _ = os.ReadFile(rulesPath)
+	assert.NoError(t, yaml.Unmarshal(bytes, &provisioning))
+	assert.Equal(t, dashboard.Panels[3].Id, provisioning.Groups[0].Rules[0].PanelId)
+
+	// Generated panels kept by the template are still linked to
+	generator.DashboardTemplate = writeTemplate(t, `{"title": {{quote .Title}}, "uid": {{quote .Uid}}, "schemaVersion": {{.SchemaVersion}}, "panels": {{json .Dashboard.Panels}}}`)
+	assert.NoError(t, generator.GenerateGrafanaDashboard(dashboardFile.Name(), metrics, nil, nil))
+	assert.Contains(t, generator.panelIds, "prefix_g")
+
+	generator.DashboardTemplate = writeTemplate(t, `{"panels": [{"id": {{panelId "custom/g"}}}, {"id": {{panelId "custom/g"}}}]}`)
+	err = generator.GenerateGrafanaDashboard(dashboardFile.Name(), metrics, nil, nil)
+	assert.ErrorContains(t, err, `panelId "custom/g" is already taken, use panelRef to refer to its panel`)
+
+	generator.DashboardTemplate = writeTemplate(t, "{\n  \"title\": {{.Title}}\n}")
+	err = generator.GenerateGrafanaDashboard(dashboardFile.Name(), metrics, nil, nil)
+	assert.ErrorContains(t, err, "produced invalid JSON: invalid character 'p' looking for beginning of value at line 2, column 13:\n  \"title\": prefix Visualised Metrics")
+
+	generator.DashboardTemplate = writeTemplate(t, `{{metric "missing"}}`)
+	err = generator.GenerateGrafanaDashboard(dashboardFile.Name(), metrics, nil, nil)
+	assert.ErrorContains(t, err, "no metric missing")
+}
+
+func TestDashboardMerge(t *testing.T) {
+	loadedPkgs, err := packages.Load(&scanConf, "")
+	assert.NoError(t, err)
+
+	generator := &DashboardGenerator{MergeDashboard: true}
+	metrics, _ := generator.DiscoverMetrics(loadedPkgs)
+
+	dashboardPath := filepath.Join(t.TempDir(), "dash.json")
+	assert.NoError(t, generator.GenerateGrafanaDashboard(dashboardPath, metrics, nil, nil))
+
+	var dashboard jsonObject
+	bytes, _ := os.ReadFile(dashboardPath)
+	assert.NoError(t, json.Unmarshal(bytes, &dashboard))
+
+	byKey := make(map[string]jsonObject)
+	var panels []interface{}
+	for _, each := range objects(dashboard["panels"]) {
+		key, _ := ownership(each)
+		byKey[key] = each
+		if key != "prefix_hb/heatmap" { // Deleted by hand, so should come back
+			panels = append(panels, each)
+		}
+	}
+
+	byKey["prefix_c/cumulative"]["gridPos"] = jsonObject{"h": 4, "w": 4, "x": 20, "y": 0} // Just moved
+	byKey["prefix_g/current"]["title"] = "Edited"                                         // Nothing new generated
+	byKey["prefix_s/quantiles"]["title"] = "Also edited"
+	byKey["prefix_s/quantiles"]["boulevard"].(jsonObject)["hash"] = "0" // As if generation has changed since
+	byKey["prefix_c/rate"]["pluginVersion"] = "10.4.1"                  // Saved by Grafana, not edited
+
+	panels = append(panels,
+		jsonObject{"type": "text", "title": "Notes", "gridPos": jsonObject{"h": 4, "w": 24, "x": 0, "y": 100}},
+		jsonObject{"type": "stat", "title": "Gone", "boulevard": jsonObject{"key": "prefix_gone/current", "hash": "0"}})
+	dashboard["panels"] = panels
+
+	edited, _ := json.Marshal(dashboard)
+	assert.NoError(t, os.WriteFile(dashboardPath, edited, 0o644))
+
+	generated, err := generator.buildDashboard(&dashboardData{Metrics: metrics})
+	assert.NoError(t, err)
+	generatedBytes, _ := MarshalDashboard(generated)
+
+	_, report, err := mergeDashboard(edited, generatedBytes)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hb"}, report.Added)
+	assert.Equal(t, []string{"Gone"}, report.Removed)
+	assert.Equal(t, []string{"Edited", "Notes"}, report.Kept)
+	assert.Contains(t, report.Updated, "c (per second)")
+	assert.Equal(t, []string{`panel "Also edited" was edited by hand, so was not updated. Delete it to regenerate it.`}, report.Conflicts)
+
+	assert.NoError(t, generator.GenerateGrafanaDashboard(dashboardPath, metrics, nil, nil))
+
+	var merged Dashboard
+	bytes, _ = os.ReadFile(dashboardPath)
+	assert.NoError(t, json.Unmarshal(bytes, &merged))
+
+	titles := panelTitles(&merged)
+	assert.Contains(t, titles, "Notes")
+	assert.Contains(t, titles, "Edited")
+	assert.Contains(t, titles, "Also edited")
+	assert.NotContains(t, titles, "Gone")
+	assert.Equal(t, "hb", titles[len(titles)-1])
+
+	last := merged.Panels[len(merged.Panels)-1]
+	assert.Equal(t, GridPos{X: 12, Y: 104, W: 12, H: 9}, last.GridPos)
+
+	for _, each := range merged.Panels {
+		if each.Boulevard != nil && each.Boulevard.Key == "prefix_c/cumulative" {
+			assert.Equal(t, GridPos{X: 20, Y: 0, W: 4, H: 4}, each.GridPos)
+		}
+	}
+
+	notes := findPanel(merged.Panels, "Notes")
+	assert.NotZero(t, notes.Id)
+	assert.Equal(t, GridPos{X: 0, Y: 100, W: 24, H: 4}, notes.GridPos)
+}
+
+func TestDashboardMergeHandAddedPanels(t *testing.T) {
+	loadedPkgs, err := packages.Load(&scanConf, "")
+	assert.NoError(t, err)
+
+	generator := &DashboardGenerator{MergeDashboard: true}
+	metrics, _ := generator.DiscoverMetrics(loadedPkgs)
+
+	// A hand-added panel took the id the counter's panel would get, and another sits in a row no longer generated
+	takenId := panelIdForKey("prefix_c/cumulative")
+	existing := fmt.Sprintf(`{"title": "T", "uid": "prefix_generated", "schemaVersion": 39, "panels": [
+  {"id": %d, "type": "text", "title": "Mine", "gridPos": {"h": 2, "w": 24, "x": 0, "y": 0}},
+  {"id": 7, "type": "row", "title": "Old", "collapsed": true, "gridPos": {"h": 1, "w": 24, "x": 0, "y": 2}, "boulevard": {"key": "old/row", "hash": "0"}, "panels": [
+    {"id": 8, "type": "stat", "title": "Old stat", "gridPos": {"h": 4, "w": 12, "x": 0, "y": 3}, "boulevard": {"key": "old/current", "hash": "0"}},
+    {"id": 9, "type": "text", "title": "Runbook", "gridPos": {"h": 4, "w": 12, "x": 12, "y": 3}}
+  ]}
+]}`, takenId)
+
+	dashboardPath := filepath.Join(t.TempDir(), "dash.json")
+	assert.NoError(t, os.WriteFile(dashboardPath, []byte(existing), 0o644))
+	assert.NoError(t, generator.GenerateGrafanaDashboard(dashboardPath, metrics, nil, nil))
+
+	var merged Dashboard
+	bytes, _ := os.ReadFile(dashboardPath)
+	assert.NoError(t, json.Unmarshal(bytes, &merged))
+
+	assert.Equal(t, takenId, findPanel(merged.Panels, "Mine").Id)
+	for _, each := range merged.Panels {
+		if each.Boulevard != nil && each.Boulevard.Key == "prefix_c/cumulative" {
+			assert.NotEqual(t, takenId, each.Id)
+		}
+	}
+
+	runbook := findPanel(merged.Panels, "Runbook")
+	assert.NotNil(t, runbook)
+	assert.Equal(t, 9, runbook.Id)
+	assert.Equal(t, 12, runbook.GridPos.X)
+	assert.NotContains(t, panelTitles(&merged), "Old stat")
+
+	_, report, err := mergeDashboard([]byte(existing), []byte(`{"panels": []}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Old", "Old stat"}, report.Removed)
+	assert.Equal(t, []string{"Mine", "Runbook"}, report.Kept)
+}
+
+func TestMultipleDashboards(t *testing.T) {
+	loadedPkgs, err := packages.Load(&scanConf, "")
+	assert.NoError(t, err)
+
+	generator := &DashboardGenerator{DashboardUid: "my-dash", Dashboards: DashboardSplitOptions{By: DashboardsByAnnotation, Folder: "My Service"}}
+	metrics, _ := generator.DiscoverMetrics(loadedPkgs)
+
+	dashboardPath := filepath.Join(t.TempDir(), "dash.json")
+	assert.NoError(t, generator.GenerateGrafanaDashboard(dashboardPath, metrics, nil, nil))
+
+	readDashboard := func(path string) *Dashboard {
+		var dashboard Dashboard
+		bytes, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.NoError(t, json.Unmarshal(bytes, &dashboard))
+		return &dashboard
+	}
+
+	main := readDashboard(dashboardPath)
+	sizes := readDashboard(filepath.Join(filepath.Dir(dashboardPath), "dash_sizes.json"))
+
+	assert.Equal(t, "my-dash", main.Uid)
+	assert.Equal(t, "my-dash-sizes", sizes.Uid)
+	assert.Equal(t, "prefix Visualised Metrics: Sizes", sizes.Title)
+	assert.Equal(t, []string{"Histograms", "h", "hb", "Gauges", "g (current)", "g (over time)"}, panelTitles(sizes))
+	assert.NotContains(t, panelTitles(main), "hb")
+
+	// Alert annotations only go on the dashboard with the error panel
+	assert.Equal(t, 3, len(main.Annotations.List))
+	assert.Equal(t, 1, len(sizes.Annotations.List))
+
+	for _, each := range []*Dashboard{main, sizes} {
+		assert.Equal(t, []string{"prefix"}, each.Tags)
+		assert.Equal(t, []DashboardLink{{Title: "Dashboards", Type: "dashboards", Tags: []string{"prefix"}, AsDropdown: true, IncludeVars: true, KeepTime: true}}, each.Links)
+	}
+
+	var provisioning DashboardProvisioning
+	bytes, err := os.ReadFile(filepath.Join(filepath.Dir(dashboardPath), "dashboard_provider.yaml"))
+	assert.NoError(t, err)
+	assert.NoError(t, yaml.Unmarshal(bytes, &provisioning))
+	assert.Equal(t, "My Service", provisioning.Providers[0].Folder)
+	assert.Equal(t, "/var/lib/grafana/dashboards/my-service", provisioning.Providers[0].Options.Path)
+
+	// Overview, with every metric's first panel, plus all the errors
+	generator.Dashboards.By = OverviewAndDetail
+	assert.NoError(t, generator.GenerateGrafanaDashboard(dashboardPath, metrics, nil, nil))
+
+	overview := readDashboard(dashboardPath)
+	assert.Equal(t, []string{"timeseries", "table", "timeseries", "timeseries", "timeseries", "heatmap", "heatmap", "stat", "bargauge", "bargauge", "gauge"}, panelTypes(&Dashboard{Panels: withoutRows(overview.Panels)}))
+	assert.Equal(t, "my-dash-sizes", readDashboard(filepath.Join(filepath.Dir(dashboardPath), "dash_sizes.json")).Uid)
+
+	generator.Dashboards.By = "bad"
+	assert.ErrorContains(t, generator.GenerateGrafanaDashboard(dashboardPath, metrics, nil, nil), `unsupported dashboard split "bad"`)
+}
+
+func TestPartUids(t *testing.T) {
+	generator := &DashboardGenerator{DashboardUid: "my-dash"}
+	assert.Equal(t, "my-dash-payment-reconciliati", generator.partUid(&dashboardPart{name: "Payment Reconciliation And Settlement Processing"}))
+
+	// Exactly 40 characters, with nowhere to split the UID
+	generator.DashboardUid = "abcdefghijklmnopqrstuvwxyz012"
+	assert.Equal(t, "abcdefghijklmnopqrstuvwxyz012-orders1234", generator.partUid(&dashboardPart{name: "orders1234"}))
+
+	generator.DashboardUid = "abcdefghijklmnopqrstuvwxyz0123456789abcd"
+	assert.Equal(t, "abcdefghijklmnopqrstuvwxyz012-orders1234", generator.partUid(&dashboardPart{name: "orders1234"}))
+	assert.Equal(t, "abcdefghijklmnopqrs-payment-reconciliati", generator.partUid(&dashboardPart{name: "Payment Reconciliation And Settlement Processing"}))
+	assert.Equal(t, "abcdefghijklmnopqrstuvwxyz0123456789ab-x", generator.partUid(&dashboardPart{name: "x"}))
+}
+
+func TestREDRow(t *testing.T) {
+	loadedPkgs, err := packages.Load(&scanConf, "")
+	assert.NoError(t, err)
+
+	generator := &DashboardGenerator{}
+	metrics, _ := generator.DiscoverMetrics(loadedPkgs)
+
+	dashboard, err := generator.buildDashboard(&dashboardData{Metrics: metrics, REDSource: metrics})
+	assert.NoError(t, err)
+
+	selector := `namespace=~"$namespace",job=~"$job",instance=~"$instance"`
+	assert.Equal(t, []string{"RED", "Request rate", "Error ratio", "p99 latency", "Errors"}, panelTitles(&Dashboard{Panels: dashboard.Panels[:5]}))
+	assert.Equal(t, []string{"row", "stat", "stat", "stat"}, panelTypes(&Dashboard{Panels: dashboard.Panels[:4]}))
+	assert.Equal(t, "sum(rate(prefix_h_count{"+selector+"}[$__rate_interval]))", dashboard.Panels[1].Targets[0].Expr)
+	assert.Equal(t, "(sum(rate(prefix_errors{"+selector+"}[$__rate_interval]))) / (sum(rate(prefix_h_count{"+selector+"}[$__rate_interval])))", dashboard.Panels[2].Targets[0].Expr)
+	assert.Equal(t, "histogram_quantile(0.99, sum(rate(prefix_h_bucket{"+selector+"}[$__rate_interval])) by (le))", dashboard.Panels[3].Targets[0].Expr)
+	assert.Equal(t, "s", dashboard.Panels[3].FieldConfig.Defaults.Unit)
+
+	generator.redMetrics = &redMetrics{requests: "c", errors: "e", duration: "t"}
+	dashboard, err = generator.buildDashboard(&dashboardData{Metrics: metrics, REDSource: metrics})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "sum(rate(prefix_c{"+selector+"}[$__rate_interval]))", dashboard.Panels[1].Targets[0].Expr)
+	assert.Equal(t, "(sum(rate(prefix_errors{"+selector+`,error_type="e"}[$__rate_interval]))) / (sum(rate(prefix_c{`+selector+"}[$__rate_interval])))", dashboard.Panels[2].Targets[0].Expr)
+	assert.Equal(t, "max(prefix_t{"+selector+`,quantile="0.99"})`, dashboard.Panels[3].Targets[0].Expr)
+
+	generator.redMetrics = &redMetrics{duration: "missing"}
+	_, err = generator.buildDashboard(&dashboardData{Metrics: metrics, REDSource: metrics})
+	assert.EqualError(t, err, "no metric missing for the RED row")
+}
+
+func TestExternalMetricFamilies(t *testing.T) {
+	loadedPkgs, err := packages.Load(&scanConf, "")
+	assert.NoError(t, err)
+
+	var families []ExternalMetricFamily
+	assert.NoError(t, yaml.Unmarshal([]byte(`
+- name: grpc_server_handled_total
+  type: counter
+  labelfilters: {grpc_service: payments}
+  labels: [grpc_code]
+  title: "gRPC {{.LabelFilters.grpc_service}}"
+  alert: {name: grpcFailures, threshold: 5, summary: Too many gRPC calls}
+- name: http_request_duration_seconds
+  type: histogram
+`), &families))
+
+	generator := &DashboardGenerator{GrafanaUrl: "https://grafana.example.com", ExternalMetrics: families, Layout: LayoutOptions{Rows: NoRows}}
+	metrics, _ := generator.DiscoverMetrics(loadedPkgs)
+
+	dashboardPath := filepath.Join(t.TempDir(), "dash.json")
+	assert.NoError(t, generator.GenerateGrafanaDashboard(dashboardPath, metrics, nil, []string{"status"}))
+
+	var dashboard Dashboard
+	bytes, _ := os.ReadFile(dashboardPath)
+	assert.NoError(t, json.Unmarshal(bytes, &dashboard))
+
+	external := dashboard.Panels[len(dashboard.Panels)-3:]
+	assert.Equal(t, []string{"gRPC payments (per second)", "http_request_duration_seconds", "JRPC: status"}, panelTitles(&Dashboard{Panels: external}))
+
+	selector := `namespace=~"$namespace",job=~"$job",instance=~"$instance"`
+	assert.Equal(t, `sum(rate(grpc_server_handled_total{grpc_service="payments",`+selector+`}[15m])) by (grpc_code)`, external[0].Targets[0].Expr)
+	assert.Equal(t, "sum(rate(http_request_duration_seconds_bucket{"+selector+"}[$__rate_interval])) by (le)", external[1].Targets[0].Expr)
+	assert.Equal(t, "s", external[1].Options.(map[string]interface{})["yAxis"].(map[string]interface{})["unit"])
+	assert.Equal(t, `avg(prefix_jsonrpc2_server{method="status",`+selector+`,quantile=~"0.5|0.75|0.9|0.99"}) by (quantile)`, external[2].Targets[0].Expr)
+	assert.Equal(t, "Alert: ApplicationGrpcFailures", external[0].Links[0].Title)
+	assert.Equal(t, []ThresholdStep{{Color: "green"}, {Color: "red", Value: floatPtr(5)}}, external[0].FieldConfig.Defaults.Thresholds.Steps)
+
+	rulesPath := filepath.Join(t.TempDir(), "rules.yaml")
+	_, err = generator.GenerateAlertRules(rulesPath, OutputOptions{AlertRuleFormat: PrometheusAlertManagerFormat})
+	assert.NoError(t, err)
+
+	var rules AlertRulesGroup
+	bytes, _ = os.ReadFile(rulesPath)
+	assert.NoError(t, yaml.Unmarshal(bytes, &rules))
+
+	rule := rules.Rules[len(rules.Rules)-1]
+	assert.Equal(t, "ApplicationGrpcFailures", rule.Alert)
+	assert.Equal(t, `sum(rate(grpc_server_handled_total{grpc_service="payments"}[5m])) > 5`, rule.Expr)
+	assert.Equal(t, "https://grafana.example.com/d/prefix_generated?viewPanel="+strconv.Itoa(external[0].Id), rule.Annotations["panel_url"])
+
+	generator.ExternalMetrics = []ExternalMetricFamily{{Name: "x", Type: "meter"}}
+	assert.EqualError(t, generator.GenerateGrafanaDashboard(dashboardPath, metrics, nil, nil), `external metric x has unsupported type "meter"`)
+
+	generator.ExternalMetrics = []ExternalMetricFamily{{Name: "x", Type: "counter", Alert: &ExternalMetricAlert{Threshold: "5"}}}
+	_, err = generator.DiscoverMetrics(loadedPkgs)
+	assert.EqualError(t, err, "no name for the alert on external metric x")
+
+	generator.ExternalMetrics = []ExternalMetricFamily{{Name: "x", Type: "counter", Alert: &ExternalMetricAlert{Name: "xs"}}}
+	_, err = generator.DiscoverMetrics(loadedPkgs)
+	assert.EqualError(t, err, `bad threshold for external metric x: strconv.ParseFloat: parsing "": invalid syntax`)
+}
+
+func TestRuntimeRow(t *testing.T) {
+	loadedPkgs, err := packages.Load(&scanConf, "")
+	assert.NoError(t, err)
+
+	generator := &DashboardGenerator{GrafanaUrl: "https://grafana.example.com", Runtime: RuntimeOptions{Row: true, Job: "payments", GoroutineLeakThreshold: 1000, FdExhaustionRatio: 0.8}}
+	metrics, err := generator.DiscoverMetrics(loadedPkgs)
+	assert.NoError(t, err)
+
+	dashboardPath := filepath.Join(t.TempDir(), "dash.json")
+	assert.NoError(t, generator.GenerateGrafanaDashboard(dashboardPath, metrics, nil, nil))
+
+	var dashboard Dashboard
+	bytes, _ := os.ReadFile(dashboardPath)
+	assert.NoError(t, json.Unmarshal(bytes, &dashboard))
+
+	runtime := dashboard.Panels[len(dashboard.Panels)-7:]
+	assert.Equal(t, []string{"Runtime", "Goroutines", "GC pause (average)", "Heap in use", "CPU", "Open file descriptors", "File descriptors used"}, panelTitles(&Dashboard{Panels: runtime}))
+
+	selector := `namespace=~"$namespace",job=~"$job",instance=~"$instance"`
+	assert.Equal(t, "sum(go_goroutines{"+selector+"}) by (instance)", runtime[1].Targets[0].Expr)
+	assert.Equal(t, "bytes", runtime[3].FieldConfig.Defaults.Unit)
+	assert.Equal(t, "sum(rate(process_cpu_seconds_total{"+selector+"}[$__rate_interval])) by (instance)", runtime[4].Targets[0].Expr)
+	assert.Equal(t, "sum(process_max_fds{"+selector+"}) by (instance)", runtime[5].Targets[1].Expr)
+	assert.Empty(t, runtime[5].Links)
+	assert.Equal(t, "sum(process_open_fds{"+selector+"}) by (instance) / sum(process_max_fds{"+selector+"}) by (instance)", runtime[6].Targets[0].Expr)
+	assert.Equal(t, "percentunit", runtime[6].FieldConfig.Defaults.Unit)
+	assert.Equal(t, "Alert: ApplicationFdExhaustion", runtime[6].Links[0].Title)
+	assert.Equal(t, []ThresholdStep{{Color: "green"}, {Color: "red", Value: floatPtr(0.8)}}, runtime[6].FieldConfig.Defaults.Thresholds.Steps)
+	assert.Equal(t, map[string]interface{}{"mode": "line"}, runtime[6].FieldConfig.Defaults.Custom["thresholdsStyle"])
+	assert.Equal(t, []ThresholdStep{{Color: "green"}, {Color: "red", Value: floatPtr(1000)}}, runtime[1].FieldConfig.Defaults.Thresholds.Steps)
+
+	rulesPath := filepath.Join(t.TempDir(), "rules.yaml")
+	_, err = generator.GenerateAlertRules(rulesPath, OutputOptions{AlertRuleFormat: PrometheusAlertManagerFormat})
+	assert.NoError(t, err)
+
+	var rules AlertRulesGroup
+	bytes, _ = os.ReadFile(rulesPath)
+	assert.NoError(t, yaml.Unmarshal(bytes, &rules))
+
+	assert.Equal(t, 4, len(rules.Rules)) // Two of ours, and no service down alert unless asked
+	leak, fds := rules.Rules[len(rules.Rules)-2], rules.Rules[len(rules.Rules)-1]
+	assert.Equal(t, "ApplicationGoroutineLeak", leak.Alert)
+	assert.Equal(t, `max by (instance) (go_goroutines{job="payments"}) > 1000`, leak.Expr)
+	assert.Equal(t, "15m", leak.Duration)
+	assert.Equal(t, `max by (instance) (process_open_fds{job="payments"} / process_max_fds{job="payments"}) > 0.8`, fds.Expr)
+	assert.Equal(t, "https://grafana.example.com/d/prefix_generated?viewPanel="+strconv.Itoa(runtime[6].Id), fds.Annotations["panel_url"])
+
+	generator.Runtime.Job = ""
+	_, err = generator.DiscoverMetrics(loadedPkgs)
+	assert.EqualError(t, err, "runtime alerts need a job")
+}
+
+func TestDashboardMetadata(t *testing.T) {
+	loadedPkgs, err := packages.Load(&scanConf, "")
+	assert.NoError(t, err)
+
+	generator := &DashboardGenerator{Metadata: DashboardMetadataOptions{
+		Description: "Payments service",
+		TimeFrom:    "now-6h",
+		Refresh:     "30s",
+		Timezone:    "Europe/London",
+		ReadOnly:    true,
+		Links: []LinkOptions{
+			{Kind: RunbookLink, Url: "https://wiki.example.com/payments"},
+			{Kind: RepoLink, Title: "Source", Url: "https://github.com/example/payments"},
+			{Kind: RelatedDashboardLink, Tags: []string{"payments"}},
+		},
+	}}
+	metrics, _ := generator.DiscoverMetrics(loadedPkgs)
+
+	dashboardPath := filepath.Join(t.TempDir(), "dash.json")
+	assert.NoError(t, generator.GenerateGrafanaDashboard(dashboardPath, metrics, []string{"payments", "generated"}, nil))
+
+	var dashboard Dashboard
+	bytes, _ := os.ReadFile(dashboardPath)
+	assert.NoError(t, json.Unmarshal(bytes, &dashboard))
+
+	assert.Equal(t, []string{"payments", "generated"}, dashboard.Tags)
+	assert.Equal(t, "Payments service", dashboard.Description)
+	assert.Equal(t, TimeRange{From: "now-6h", To: "now"}, dashboard.Time)
+	assert.Equal(t, "30s", dashboard.Refresh)
+	assert.Equal(t, "Europe/London", dashboard.Timezone)
+	assert.False(t, dashboard.Editable)
+
+	assert.Equal(t, []DashboardLink{
+		{Title: "Runbook", Type: "link", Url: "https://wiki.example.com/payments", Tags: []string{}, TargetBlank: true, Icon: "doc"},
+		{Title: "Source", Type: "link", Url: "https://github.com/example/payments", Tags: []string{}, TargetBlank: true, Icon: "external link"},
+		{Title: "Related dashboards", Type: "dashboards", Tags: []string{"payments"}, AsDropdown: true, IncludeVars: true, KeepTime: true},
+	}, dashboard.Links)
+
+	link, err := ParseDashboardLink("dashboard=https://grafana.example.com/d/orders")
+	assert.NoError(t, err)
+	assert.Equal(t, LinkOptions{Kind: RelatedDashboardLink, Url: "https://grafana.example.com/d/orders"}, link)
+
+	link, err = ParseDashboardLink("dashboard=orders")
+	assert.NoError(t, err)
+	assert.Equal(t, LinkOptions{Kind: RelatedDashboardLink, Tags: []string{"orders"}}, link)
+
+	_, err = ParseDashboardLink("runbook")
+	assert.EqualError(t, err, `bad dashboard link "runbook", expected kind=url`)
+
+	generator.Metadata = DashboardMetadataOptions{Links: []LinkOptions{{Kind: "wiki", Url: "https://wiki.example.com"}}}
+	_, err = generator.buildDashboard(&dashboardData{Metrics: metrics})
+	assert.EqualError(t, err, `unsupported dashboard link kind "wiki", expected one of runbook, repo, dashboard, link`)
+
+	generator.Metadata = DashboardMetadataOptions{Links: []LinkOptions{{Kind: RunbookLink}}}
+	_, err = generator.buildDashboard(&dashboardData{Metrics: metrics})
+	assert.EqualError(t, err, `dashboard link "runbook" needs a url`)
+
+	generator.Metadata = DashboardMetadataOptions{Refresh: "often"}
+	_, err = generator.buildDashboard(&dashboardData{Metrics: metrics})
+	assert.EqualError(t, err, `bad dashboard refresh "often", expected an interval like 30s or 5m`)
+
+	generator.Metadata = DashboardMetadataOptions{Timezone: "Mars/Olympus"}
+	_, err = generator.buildDashboard(&dashboardData{Metrics: metrics})
+	assert.EqualError(t, err, `bad dashboard timezone "Mars/Olympus", expected browser, utc or an IANA name`)
+}
+
+func TestTraceAndLogLinks(t *testing.T) {
+	loadedPkgs, err := packages.Load(&scanConf, "")
+	assert.NoError(t, err)
+
+	generator := &DashboardGenerator{DataLinks: DataLinkOptions{TempoDatasourceUid: "tempo", LokiDatasourceUid: "loki", LogQuery: `{{.Selector}} |= "{{.Metric}}"`}}
+	metrics, _ := generator.DiscoverMetrics(loadedPkgs)
+
+	dashboard, err := generator.buildDashboard(&dashboardData{Metrics: metrics})
+	assert.NoError(t, err)
+
+	// Summaries have no exemplars, unlike histograms and counters
+	timer := findPanel(dashboard.Panels, "t")
+	assert.False(t, timer.Targets[0].Exemplar)
+	assert.True(t, findPanel(dashboard.Panels, "h").Targets[0].Exemplar)
+	assert.True(t, findPanel(dashboard.Panels, "c (per second)").Targets[0].Exemplar)
+	assert.Equal(t, []string{"Traces", "Logs"}, []string{timer.FieldConfig.Defaults.Links[0].Title, timer.FieldConfig.Defaults.Links[1].Title})
+
+	traces, err := url.Parse(timer.FieldConfig.Defaults.Links[0].Url)
+	assert.NoError(t, err)
+	assert.Equal(t, "/explore", traces.Path)
+
+	var left map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(traces.Query().Get("left")), &left))
+	assert.Equal(t, map[string]interface{}{"from": "${__from}", "to": "${__to}"}, left["range"])
+	assert.Equal(t, map[string]interface{}{"refId": "A", "datasource": map[string]interface{}{"type": "tempo", "uid": "tempo"}, "queryType": "traceql", "query": `{ resource.service.name =~ "${job:regex}" }`}, left["queries"].([]interface{})[0])
+	assert.Contains(t, timer.FieldConfig.Defaults.Links[0].Url, "${job:regex}")
+
+	logs, _ := url.Parse(timer.FieldConfig.Defaults.Links[1].Url)
+	assert.NoError(t, json.Unmarshal([]byte(logs.Query().Get("left")), &left))
+	assert.Equal(t, `{namespace=~"${namespace:regex}",job=~"${job:regex}",instance=~"${instance:regex}",job=~".+"} |= "prefix_t"`, left["queries"].([]interface{})[0].(map[string]interface{})["expr"])
+
+	gauge := findPanel(dashboard.Panels, "g (over time)")
+	assert.False(t, gauge.Targets[0].Exemplar)
+	assert.Equal(t, "Logs", gauge.FieldConfig.Defaults.Links[0].Title)
+
+	// Loki refuses a selector that only has matchers for empty values
+	for query, ok := range map[string]bool{
+		`{{.Selector}}`:                       true,
+		`{app="payments"} |= "{{.Metric}}"`:   true,
+		"{app=~`pay.*`}":                      true,
+		"{app=~`.*`}":                         false,
+		`{job=~"${job:regex}"}`:               false,
+		`{job=~"${job:regex}", app!="x"}`:     false,
+		`{job=~"${job:regex}|x", app=~"x.+"}`: true,
+	} {
+		generator.DataLinks = DataLinkOptions{LokiDatasourceUid: "loki", LogQuery: query}
+		_, err = generator.buildDashboard(&dashboardData{Metrics: metrics})
+		if ok {
+			assert.NoError(t, err, query)
+		} else {
+			assert.ErrorContains(t, err, "Loki needs a matcher that can't match an empty value", query)
+		}
+	}
+
+	generator.DataLinks = DataLinkOptions{LokiDatasourceUid: "loki", LogQuery: `{job="x"`}
+	_, err = generator.buildDashboard(&dashboardData{Metrics: metrics})
+	assert.EqualError(t, err, `bad log query "{job=\"x\"": unclosed {`)
+
+	generator.DataLinks = DataLinkOptions{TempoDatasourceUid: "tempo", TraceQuery: `duration > 1s`}
+	_, err = generator.buildDashboard(&dashboardData{Metrics: metrics})
+	assert.EqualError(t, err, `bad trace query "duration > 1s": expected it to start with a {selector}`)
+
+	generator.DataLinks = DataLinkOptions{TempoDatasourceUid: "tempo", TraceQuery: `{{.Service}}`}
+	_, err = generator.buildDashboard(&dashboardData{Metrics: metrics})
+	assert.ErrorContains(t, err, "bad trace query: ")
+
+	generator.DataLinks = DataLinkOptions{LokiDatasourceUid: "my loki"}
+	_, err = generator.buildDashboard(&dashboardData{Metrics: metrics})
+	assert.EqualError(t, err, `bad log datasource UID "my loki"`)
+}
+
+func findPanel(panels []*Panel, title string) *Panel {
+	for _, each := range panels {
+		if each.Title == title {
+			return each
+		}
+		if nested := findPanel(each.Panels, title); nested != nil {
+			return nested
+		}
+	}
+	return nil
+}
+
+func TestDashboardValidation(t *testing.T) {
+	dashboard := `{"title": "T", "uid": "u", "schemaVersion": 16, "panels": [
+  {"id": 1, "type": "timeseries", "title": "New", "gridPos": {"h": 8, "w": 12, "x": 0, "y": 0}, "boulevard": {"key": "new"}},
+  {"id": 2, "type": "sparkline", "title": "Unknown", "gridPos": {"h": 8, "w": 12, "x": 6, "y": 4}, "boulevard": {"key": "unknown"}},
+  {"id": 2, "type": "graph", "title": "Same id", "gridPos": {"h": 8, "w": 20, "x": 12, "y": 20}},
+  {"id": 3, "type": "acme-clock-panel", "title": "Plugin"},
+  {"id": 4, "type": "row", "title": "Collapsed", "collapsed": true, "gridPos": {"h": 1, "w": 24, "x": 0, "y": 30}, "panels": [
+    {"id": 5, "title": "Nested", "type": "graph", "gridPos": {"h": 8, "w": 12, "x": 0, "y": 31}},
+    {"id": 1, "title": "Nested twice", "type": "graph", "gridPos": {"h": 8, "x": 0, "y": 31}}
+  ]}
+]}`
+
+	_, err := validateDashboard("dash.json", []byte(dashboard), 16)
+
+	var validationErr *DashboardValidationError
+	assert.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, []string{
+		`panel 1 "New" has type "timeseries", which needs schema version 27`,
+		`panel 2 "Unknown" has unknown type "sparkline"`,
+		`panel 2 "Same id" has the same id as panel 2 "Unknown"`,
+		`panel 2 "Same id" has gridPos {H:8 W:20 X:12 Y:20}, outside the 24-column grid`,
+		`panel 3 "Plugin" has no gridPos`,
+		`panel 1 "New" overlaps panel 2 "Unknown"`,
+		`panel 1 "Nested twice" has the same id as panel 1 "New"`,
+		`panel 1 "Nested twice" has no gridPos w`,
+	}, validationErr.Problems)
+	assert.True(t, strings.HasPrefix(err.Error(), "dashboard dash.json is not valid for schema version 16:\n  - panel 1 \"New\""))
+
+	_, err = validateDashboard("dash.json", []byte(`{"panels": [{"gridPos": {"h": 1, "w": 24, "x": 0, "y": 0}, "boulevard": {"key": "k"}}]}`), DefaultSchemaVersion)
+	assert.EqualError(t, err, `dashboard dash.json is not valid for schema version 39:
+  - dashboard has no title
+  - dashboard has no uid
+  - dashboard has no schemaVersion
+  - panel #1 "" has no type
+  - panel #1 "" has no id`)
+
+	_, err = validateDashboard("dash.json", []byte(`{"title": "T", "uid": "u", "schemaVersion": 39, "panels": [{"id": 1, "type": "singlestat", "gridPos": {"h": 1, "w": 1, "x": 0, "y": 0}, "boulevard": {"key": "k"}}]}`), 39)
+	assert.Error(t, err)
+
+	// The dashboard's own version wins, e.g. that of an existing dashboard merged into
+	_, err = validateDashboard("dash.json", []byte(`{"title": "T", "uid": "u", "schemaVersion": 16, "panels": [{"id": 1, "type": "stat", "gridPos": {"h": 1, "w": 1, "x": 0, "y": 0}, "boulevard": {"key": "k"}}]}`), DefaultSchemaVersion)
+	assert.EqualError(t, err, `dashboard dash.json is not valid for schema version 16:
+  - panel 1 "" has type "stat", which needs schema version 25`)
+
+	// Panels boulevard didn't generate may be of types it doesn't know, or library panels
+	warnings, err := validateDashboard("dash.json", []byte(`{"title": "T", "uid": "u", "schemaVersion": 39, "panels": [
+  {"id": 1, "type": "annolist", "title": "Annotations", "gridPos": {"h": 4, "w": 8, "x": 0, "y": 0}},
+  {"id": 4, "type": "sparkline", "title": "Sparkline", "gridPos": {"h": 4, "w": 8, "x": 0, "y": 4}},
+  {"id": 2, "title": "Shared", "gridPos": {"h": 4, "w": 8, "x": 8, "y": 0}, "libraryPanel": {"uid": "lib", "name": "Shared"}},
+  {"id": 3, "title": "Untyped", "gridPos": {"h": 4, "w": 8, "x": 16, "y": 0}}
+]}`), 39)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`panel 4 "Sparkline" has unknown type "sparkline"`, `panel 3 "Untyped" has no type`}, warnings)
+
+	loadedPkgs, err := packages.Load(&scanConf, "")
+	assert.NoError(t, err)
+
+	generator := &DashboardGenerator{}
+	metrics, _ := generator.DiscoverMetrics(loadedPkgs)
+
+	generator.DashboardTemplate = writeTemplate(t, `{"title": {{quote .Title}}, "uid": {{quote .Uid}}, "schemaVersion": {{.SchemaVersion}}, "panels": [{"id": 1, "type": "stat", "gridPos": {"h": 4, "w": 30, "x": 0, "y": 0}}]}`)
+	dashboardPath := filepath.Join(t.TempDir(), "dash.json")
+	assert.ErrorAs(t, generator.GenerateGrafanaDashboard(dashboardPath, metrics, nil, nil), &validationErr)
+	assert.NoFileExists(t, dashboardPath)
+}
+
+func TestDashboardResources(t *testing.T) {
+	loadedPkgs, err := packages.Load(&scanConf, "")
+	assert.NoError(t, err)
+
+	generator := &DashboardGenerator{DashboardResource: DashboardResourceOptions{Format: ConfigMapFormat, Namespace: "monitoring", Labels: []string{"team=payments"}}}
+	metrics, _ := generator.DiscoverMetrics(loadedPkgs)
+
+	dashboardPath := filepath.Join(t.TempDir(), "grafana-dashboard.yaml")
+	assert.NoError(t, generator.GenerateGrafanaDashboard(dashboardPath, metrics, nil, nil))
+
+	var configMap ConfigMapResource
+	bytes, _ := os.ReadFile(dashboardPath)
+	assert.NoError(t, yaml.Unmarshal(bytes, &configMap))
+
+	assert.Equal(t, "ConfigMap", configMap.Kind)
+	assert.Equal(t, ObjectMeta{Name: "prefix-generated", Namespace: "monitoring",
+		Labels:      map[string]string{"grafana_dashboard": "1", "team": "payments"},
+		Annotations: map[string]string{"grafana_folder": "Application"}}, configMap.Metadata)
+
+	var dashboard Dashboard
+	assert.NoError(t, json.Unmarshal([]byte(configMap.Data["grafana-dashboard.json"]), &dashboard))
+	assert.Equal(t, "prefix_generated", dashboard.Uid)
+
+	// Into a chart, split, and merged into what's there
+	generator.DashboardResource = DashboardResourceOptions{Format: GrafanaDashboardFormat, Name: "payments", InstanceSelector: []string{"app=grafana"}, HelmTemplate: true}
+	generator.Dashboards = DashboardSplitOptions{By: DashboardsByAnnotation, Folder: "Payments"}
+	generator.MergeDashboard = true
+
+	dir := t.TempDir()
+	dashboardPath = filepath.Join(dir, "grafana-dashboard.yaml")
+	assert.NoError(t, generator.GenerateGrafanaDashboard(dashboardPath, metrics, nil, nil))
+	assert.NoError(t, generator.GenerateGrafanaDashboard(dashboardPath, metrics, nil, nil))
+	assert.NoFileExists(t, filepath.Join(dir, dashboardProviderFileName))
+
+	bytes, _ = os.ReadFile(filepath.Join(dir, "grafana-dashboard_sizes.yaml"))
+	text := string(bytes)
+	assert.Contains(t, text, `namespace: "{{ .Release.Namespace }}"`)
+	assert.Contains(t, text, `{{"{{"}}le{{"}}"}}`)
+	assert.NotContains(t, text, "{{le}}")
+
+	var resource GrafanaDashboardResource
+	assert.NoError(t, yaml.Unmarshal([]byte(helmDelimitersUnescaper.Replace(text)), &resource))
+	assert.Equal(t, "GrafanaDashboard", resource.Kind)
+	assert.Equal(t, "payments-sizes", resource.Metadata.Name)
+	assert.Equal(t, GrafanaDashboardSpec{InstanceSelector: LabelSelector{MatchLabels: map[string]string{"app": "grafana"}}, Folder: "Payments", Json: resource.Spec.Json}, resource.Spec)
+	assert.NoError(t, json.Unmarshal([]byte(resource.Spec.Json), &dashboard))
+	assert.Equal(t, "prefix Visualised Metrics: Sizes", dashboard.Title)
+
+	generator.DashboardResource = DashboardResourceOptions{Format: "secret"}
+	assert.EqualError(t, generator.GenerateGrafanaDashboard(dashboardPath, metrics, nil, nil), `unsupported dashboard format "secret", expected one of json, configmap, grafanadashboard`)
+}
+
+func withoutRows(panels []*Panel) []*Panel {
+	var result []*Panel
+	for _, each := range panels {
+		if each.Type != "row" {
+			result = append(result, each)
+		}
+	}
+	return result
+}
+
+func writeTemplate(t *testing.T, text string) string {
+	path := filepath.Join(t.TempDir(), "dashboard.tmpl")
+	assert.NoError(t, os.WriteFile(path, []byte(text), 0o644))
+	return path
+}
+
+func panelTitles(dashboard *Dashboard) []string {
+	titles := make([]string, len(dashboard.Panels))
+	for i, each := range dashboard.Panels {
+		titles[i] = each.Title
+	}
+	return titles
+}
+
+func variableNames(dashboard *Dashboard) []string {
+	names := make([]string, len(dashboard.Templating.List))
+	for i, each := range dashboard.Templating.List {
+		names[i] = each.Name
+	}
+	return names
+}
+
+func panelIds(dashboard *Dashboard) []int {
+	ids := make([]int, len(dashboard.Panels))
+	for i, each := range dashboard.Panels {
+		ids[i] = each.Id
+	}
+	return ids
+}
+
+func rowTitles(dashboard *Dashboard) []string {
+	var titles []string
+	for _, each := range dashboard.Panels {
+		if each.Type == "row" {
+			titles = append(titles, each.Title)
+		}
+	}
+	return titles
+}
+
+func gridPositions(dashboard *Dashboard) []GridPos {
+	positions := make([]GridPos, len(dashboard.Panels))
+	for i, each := range dashboard.Panels {
+		positions[i] = each.GridPos
+	}
+	return positions
+}
+
+func panelTypes(dashboard *Dashboard) []string {
+	types := make([]string, len(dashboard.Panels))
+	for i, each := range dashboard.Panels {
+		types[i] = each.Type
+	}
+	return types
+}
+
+var expectedAlertmanagerOutput = `
+route:
+  matchers:
+  - service="payments"
+  routes:
+  - matchers:
+    - team="payments"
+    group_by:
+    - alertname
+    routes:
+    - receiver: payments-pager
+      matchers:
+      - severity="pager"
+      repeat_interval: 1h
+    - receiver: payments-warning
+      matchers:
+      - severity="warning"
+      repeat_interval: 12h
+  - matchers:
+    - team="ledger"
+    group_by:
+    - alertname
+    routes:
+    - receiver: ledger-warning
+      matchers:
+      - severity="warning"
+      repeat_interval: 12h
+inhibit_rules:
+- source_matchers:
+  - alertname="PaymentsServiceDown"
+  target_matchers:
+  - alertname=~"PaymentsRefundError|PaymentsRefundProblems|PaymentsLedgerProblems"
+  equal:
+  - cluster
+- source_matchers:
+  - alertname="PaymentsRefundError"
+  target_matchers:
+  - alertname="PaymentsRefundProblems"
+  equal:
+  - team
+  - cluster
+`
+
+func TestAlertmanagerConfigGeneration(t *testing.T) {
+	loadedPkgs, err := packages.Load(&scanConf, "github.com/poblish/boulevard/generation/test/e")
+	assert.NoError(t, err)
+
+	generator := &DashboardGenerator{Runtime: RuntimeOptions{Job: "payments", ServiceDown: true, ServiceDownSeverity: "pager", ServiceDownDuration: "1m"}}
+	_, _ = generator.DiscoverMetrics(loadedPkgs)
+
+	tempFile, err := os.CreateTemp("", "x*.yaml")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//goland:noinspection GoUnhandledErrorResult
+	defer os.Remove(tempFile.Name())
+
+	alertMetrics, err := generator.GenerateAlertRules(tempFile.Name(), OutputOptions{AlertRuleFormat: PrometheusOperatorFormat})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, alertMetrics.Count)
+
+	bytes, _ := os.ReadFile(tempFile.Name())
+	var rules PrometheusOperatorRulesSpec
+	assert.NoError(t, yaml.Unmarshal(bytes, &rules))
+	down := rules.Groups[0].Rules[len(rules.Groups[0].Rules)-1]
+	assert.Equal(t, "PaymentsServiceDown", down.Alert)
+	assert.Equal(t, `absent(up{job="payments"} == 1) > 0`, down.Expr)
+	assert.Equal(t, "1m", down.For)
+	assert.Equal(t, map[string]string{"severity": "pager", "team": "payments"}, down.Labels)
+
+	err = generator.GenerateAlertmanagerConfig(tempFile.Name(), AlertmanagerOptions{
+		ExtraLabels:           []string{"service=payments"},
+		RepeatIntervals:       []string{"pager=1h"},
+		DefaultRepeatInterval: "12h",
+	})
+	assert.NoError(t, err)
+
+	bytes, _ = os.ReadFile(tempFile.Name())
+	assert.Equal(t, strings.TrimSpace(expectedAlertmanagerOutput), strings.TrimSpace(string(bytes)))
+
+	config, err := generator.alertmanagerConfig(generator.currentMetricPrefix, AlertmanagerOptions{SeverityOrder: []string{"warning", "pager"}, InhibitEqual: []string{"namespace", "job"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"namespace", "job"}, config.InhibitRules[0].Equal)
+	assert.Equal(t, AlertmanagerInhibitRule{SourceMatchers: []string{`alertname="PaymentsRefundProblems"`}, TargetMatchers: []string{`alertname="PaymentsRefundError"`}, Equal: []string{"team", "namespace", "job"}}, config.InhibitRules[1])
+}
+
+func TestAlertPolicy(t *testing.T) {
+	loadedPkgs, err := packages.Load(&scanConf, "")
+	assert.NoError(t, err)
+
+	generator := &DashboardGenerator{}
+	_, _ = generator.DiscoverMetrics(loadedPkgs)
+
+	tempFile, err := os.CreateTemp("", "x*.yaml")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//goland:noinspection GoUnhandledErrorResult
+	defer os.Remove(tempFile.Name())
+
+	_, err = tempFile.WriteString(`
+allowedSeverities: [pager, warning]
+allowedTeams: [myTeam, otherTeam]
+rules:
+- when: {severity: pager}
+  requiredAnnotations: [runbook_url]
+`)
+	assert.NoError(t, err)
+
+	policy, err := LoadAlertPolicy(tempFile.Name())
+	assert.NoError(t, err)
+
+	_, err = generator.GenerateAlertRules(tempFile.Name(), OutputOptions{AlertRuleFormat: PrometheusAlertManagerFormat, Policy: policy})
+	assert.Error(t, err)
+	assert.Equal(t, "alert policy violated:\n  alert ApplicationCalcError has no annotation runbook_url (required when severity=pager)", err.Error())
+
+	policy.AllowedTeams = []string{"otherTeam"}
+	policy.Rules = nil
+
+	_, err = generator.GenerateAlertRules(tempFile.Name(), OutputOptions{AlertRuleFormat: PrometheusAlertManagerFormat, Policy: policy})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `alert ApplicationCalcProblems has team "myTeam", expected one of [otherTeam]`)
+
+	policy.AllowedTeams = nil
+
+	_, err = generator.GenerateAlertRules(tempFile.Name(), OutputOptions{AlertRuleFormat: PrometheusAlertManagerFormat, Policy: policy})
+	assert.NoError(t, err)
+
+	// Blank routing labels fail with or without a policy
+	for _, each := range []*AlertPolicy{policy, nil} {
+		_, err = generator.GenerateAlertRules(tempFile.Name(), OutputOptions{AlertRuleFormat: PrometheusAlertManagerFormat, ExtraLabels: []string{"severity= "}, Policy: each})
+		assert.EqualError(t, err, "no severity for alert ApplicationCalcError")
+	}
+}
+
+func TestPanelAlertLinks(t *testing.T) {
+	loadedPkgs, err := packages.Load(&scanConf, "")
+	assert.NoError(t, err)
+
+	generator := &DashboardGenerator{DashboardUid: "my-dash", GrafanaUrl: "https://grafana.example.com/"}
+	metrics, _ := generator.DiscoverMetrics(loadedPkgs)
+
+	dashboardFile, err := os.CreateTemp("", "dash*.json")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//goland:noinspection GoUnhandledErrorResult
+	defer os.Remove(dashboardFile.Name())
+
+	assert.NoError(t, generator.GenerateGrafanaDashboard(dashboardFile.Name(), metrics, nil, nil))
+
+	var dashboard Dashboard
+	bytes, _ := os.ReadFile(dashboardFile.Name())
+	assert.NoError(t, json.Unmarshal(bytes, &dashboard))
+
+	panelId := generator.panelIds["prefix_errors{error_type='e'}"]
+	var alertedPanel *Panel
+	for _, each := range dashboard.Panels {
+		if each.Id == panelId {
+			alertedPanel = each
+		}
+	}
+
+	assert.Equal(t, []PanelLink{
+		{Title: "Alert: ApplicationCalcError", Url: "https://grafana.example.com/alerting/list?search=ApplicationCalcError", TargetBlank: true},
+		{Title: "Alert: ApplicationCalcProblems", Url: "https://grafana.example.com/alerting/list?search=ApplicationCalcProblems", TargetBlank: true},
+	}, alertedPanel.Links)
+
+	tempFile, err := os.CreateTemp("", "x*.yaml")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//goland:noinspection GoUnhandledErrorResult
+	defer os.Remove(tempFile.Name())
+
+	_, err = generator.GenerateAlertRules(tempFile.Name(), OutputOptions{AlertRuleFormat: PrometheusAlertManagerFormat})
+	assert.NoError(t, err)
+
+	var group AlertRulesGroup
+	bytes, _ = os.ReadFile(tempFile.Name())
+	assert.NoError(t, yaml.Unmarshal(bytes, &group))
+
+	assert.Equal(t, "https://grafana.example.com/d/my-dash", group.Rules[0].Annotations["dashboard_url"])
+	assert.Equal(t, fmt.Sprintf("https://grafana.example.com/d/my-dash?viewPanel=%d", panelId), group.Rules[0].Annotations["panel_url"])
+}
+
+func TestDashboardTitlesAreEscaped(t *testing.T) {
+	generator := &DashboardGenerator{}
+	dashboard, err := generator.buildDashboard(&dashboardData{
+		Title:   `The "quoted" dashboard`,
+		Metrics: []*metric{{FullMetricName: "prefix_c", PanelTitle: `say "hi" \ <b>`, MetricType: "counter"}},
+	})
+	assert.NoError(t, err)
+
+	output, err := MarshalDashboard(dashboard)
+	assert.NoError(t, err)
+
+	var parsed Dashboard
+	assert.NoError(t, json.Unmarshal(output, &parsed))
+	assert.Equal(t, `The "quoted" dashboard`, parsed.Title)
+	assert.Equal(t, "Counters", parsed.Panels[0].Title)
+	assert.Equal(t, `say "hi" \ <b> (cumulative)`, parsed.Panels[1].Title)
+	assert.Equal(t, `sum(rate(prefix_c{namespace=~"$namespace",job=~"$job",instance=~"$instance"}[15m]))`, parsed.Panels[2].Targets[0].Expr)
 }
 
 func TestInvalidErrorLabelAnnotation(t *testing.T) {
@@ -218,6 +1554,9 @@ func TestBadErrorRateAnnotations(t *testing.T) {
    	@AlertDefaults(displayPrefix = Application, severity = warning, team = myTeam)
 	@ZeroToleranceErrorAlertRule(name = calcError, errorLabel="e", severity = pager, summary = Calculation error, description = "A calculation failed unexpectedly")
 	@ElevatedErrorRateAlertRule(name = calcProblems, errorLabel="e", timeRange=10m, ratePerSecondThreshold=1, summary = More errors, description = "Too high error rate")
+	@PanelGroup(name = Geography, metrics = "places | animals")
+	@Metric(name = g, unit = bytes)
+	@Dashboard(name = Sizes, metrics = "g | h | hb")
 */
 //goland:noinspection GoUnusedFunction
 func sampleMetricUsage() { //nolint:unused,deadcode // Is used!!
@@ -0,0 +1,168 @@
+package generation
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultGrafanaDatasourceUid = "prometheus"
+const DefaultGrafanaAlertInterval = "1m"
+
+const grafanaExpressionDatasourceUid = "__expr__"
+
+// GrafanaAlertingOptions configures the Grafana alerting provisioning file written for GrafanaAlertingFormat
+type GrafanaAlertingOptions struct {
+	OrgId         int    // Defaults to 1
+	Folder        string // Defaults to the display prefix
+	RuleGroup     string // Defaults to the alert group name used by the other formats
+	Interval      string // Rule group evaluation interval, defaults to DefaultGrafanaAlertInterval
+	DatasourceUid string // Prometheus datasource queried by each rule, defaults to DefaultGrafanaDatasourceUid
+
+	// Links each rule back to its panel. Both are filled in from the last generated dashboard where not set.
+	DashboardUid       string
+	PanelIds           map[string]int
+	PanelDashboardUids map[string]string // Where a panel is on another of the dashboards
+}
+
+// GrafanaAlertingProvisioning https://grafana.com/docs/grafana/latest/alerting/set-up/provision-alerting-resources/file-provisioning/
+type GrafanaAlertingProvisioning struct {
+	ApiVersion int                     `yaml:"apiVersion"`
+	Groups     []GrafanaAlertRuleGroup `yaml:"groups"`
+}
+
+type GrafanaAlertRuleGroup struct {
+	OrgId    int                `yaml:"orgId"`
+	Name     string             `yaml:"name"`
+	Folder   string             `yaml:"folder"`
+	Interval string             `yaml:"interval"`
+	Rules    []GrafanaAlertRule `yaml:"rules"`
+}
+
+type GrafanaAlertRule struct {
+	Uid          string              `yaml:"uid"`
+	Title        string              `yaml:"title"`
+	Condition    string              `yaml:"condition"`
+	Data         []GrafanaAlertQuery `yaml:"data"`
+	DashboardUid string              `yaml:"dashboardUid,omitempty"`
+	PanelId      int                 `yaml:"panelId,omitempty"`
+	NoDataState  string              `yaml:"noDataState"`
+	ExecErrState string              `yaml:"execErrState"`
+	For          string              `yaml:"for"`
+	Annotations  map[string]string   `yaml:"annotations"`
+	Labels       map[string]string   `yaml:"labels"`
+}
+
+type GrafanaAlertQuery struct {
+	RefId             string                    `yaml:"refId"`
+	RelativeTimeRange *GrafanaRelativeTimeRange `yaml:"relativeTimeRange,omitempty"`
+	DatasourceUid     string                    `yaml:"datasourceUid"`
+	Model             map[string]interface{}    `yaml:"model"`
+}
+
+type GrafanaRelativeTimeRange struct {
+	From int `yaml:"from"`
+	To   int `yaml:"to"`
+}
+
+var invalidGrafanaUidChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+func (opts GrafanaAlertingOptions) ruleGroup(defaultFolder string, defaultName string, rules []GrafanaAlertRule) GrafanaAlertingProvisioning {
+	group := GrafanaAlertRuleGroup{OrgId: opts.OrgId, Name: opts.RuleGroup, Folder: opts.Folder, Interval: opts.Interval, Rules: rules}
+	if group.OrgId == 0 {
+		group.OrgId = 1
+	}
+	if group.Name == "" {
+		group.Name = defaultName
+	}
+	if group.Folder == "" {
+		group.Folder = defaultFolder
+	}
+	if group.Interval == "" {
+		group.Interval = DefaultGrafanaAlertInterval
+	}
+
+	return GrafanaAlertingProvisioning{ApiVersion: 1, Groups: []GrafanaAlertRuleGroup{group}}
+}
+
+// rule builds a Grafana-managed rule that queries Prometheus (A) and applies the rule's threshold to the result (B)
+func (opts GrafanaAlertingOptions) rule(alertName string, query string, threshold string, timeRange string, duration string,
+	alertMetricFqn string, labels map[string]string, annotations map[string]string) (GrafanaAlertRule, error) {
+
+	thresholdValue, err := strconv.ParseFloat(threshold, 64)
+	if err != nil {
+		return GrafanaAlertRule{}, err
+	}
+
+	datasourceUid := opts.DatasourceUid
+	if datasourceUid == "" {
+		datasourceUid = DefaultGrafanaDatasourceUid
+	}
+
+	rule := GrafanaAlertRule{
+		Uid:       grafanaRuleUid(alertName),
+		Title:     alertName,
+		Condition: "B",
+		Data: []GrafanaAlertQuery{
+			{
+				RefId:             "A",
+				RelativeTimeRange: &GrafanaRelativeTimeRange{From: relativeTimeRangeSeconds(timeRange)},
+				DatasourceUid:     datasourceUid,
+				Model:             map[string]interface{}{"refId": "A", "expr": query, "instant": true},
+			},
+			{
+				RefId:         "B",
+				DatasourceUid: grafanaExpressionDatasourceUid,
+				Model: map[string]interface{}{
+					"refId":      "B",
+					"type":       "threshold",
+					"expression": "A",
+					"conditions": []interface{}{map[string]interface{}{"evaluator": map[string]interface{}{"type": "gt", "params": []float64{thresholdValue}}}},
+				},
+			},
+		},
+		NoDataState:  "OK",
+		ExecErrState: "Error",
+		For:          duration,
+		Annotations:  annotations,
+		Labels:       labels,
+	}
+
+	if panelId, ok := opts.PanelIds[alertMetricFqn]; ok && opts.DashboardUid != "" {
+		rule.DashboardUid = opts.DashboardUid
+		if uid, ok := opts.PanelDashboardUids[alertMetricFqn]; ok {
+			rule.DashboardUid = uid
+		}
+		rule.PanelId = panelId
+
+		annotations["__dashboardUid__"] = rule.DashboardUid
+		annotations["__panelId__"] = strconv.Itoa(panelId)
+	}
+
+	return rule, nil
+}
+
+// grafanaRuleUid is the alert name, made safe for a UID. One too long is cut short and ends with a hash of the whole
+// name, so that alerts whose names only differ near the end still get their own UIDs.
+func grafanaRuleUid(alertName string) string {
+	uid := invalidGrafanaUidChars.ReplaceAllString(strings.ToLower(alertName), "-")
+	if len(uid) <= maxGrafanaUidLength {
+		return uid
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(alertName))
+	hash := fmt.Sprintf("%08x", h.Sum32())
+	return strings.TrimRight(uid[:maxGrafanaUidLength-len(hash)-1], "-") + "-" + hash
+}
+
+// Query range to evaluate over, at least covering the PromQL range so that `rate()` has samples
+func relativeTimeRangeSeconds(timeRange string) int {
+	if d, err := time.ParseDuration(timeRange); err == nil && d >= 10*time.Minute {
+		return int(d.Seconds())
+	}
+	return 600
+}
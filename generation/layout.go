@@ -0,0 +1,276 @@
+package generation
+
+import (
+	"fmt"
+	"go/ast"
+	"log"
+	"strconv"
+	"strings"
+)
+
+const dashboardWidth = 24
+
+const (
+	RowsByMetricType = "type"
+	RowsByPackage    = "package"
+	RowsByAnnotation = "annotation"
+	NoRows           = "none"
+)
+
+// DefaultPanelSizes are width x height in grid units, by panel type
+var DefaultPanelSizes = map[string]GridPos{
+	"stat":     {W: 6, H: 6},
+	"gauge":    {W: 6, H: 6},
+	"bargauge": {W: 12, H: 9},
+	"table":    {W: 12, H: 9},
+}
+
+var defaultPanelSize = GridPos{W: 12, H: 9}
+
+// Row order when grouping by metric type
+var metricTypeRows = []struct {
+	metricType string
+	title      string
+}{
+	{"errors", "Errors"},
+	{"timer", "Timers"},
+	{"summary", "Summaries"},
+	{"histogram", "Histograms"},
+	{"counter", "Counters"},
+	{"gauge", "Gauges"},
+	{"external", "External"},
+}
+
+// LayoutOptions controls how panels are grouped into rows and sized
+type LayoutOptions struct {
+	Rows         string   // RowsByMetricType (default), RowsByPackage, RowsByAnnotation or NoRows
+	CollapseRows bool     // Emit rows collapsed, with their panels nested inside
+	PanelSizes   []string // panelType=WxH, e.g. stat=8x4
+}
+
+// layoutEntry is a generated panel awaiting its place on the dashboard
+type layoutEntry struct {
+	panel       *Panel
+	key         string // stable identity of the panel, from which its id is derived
+	row         string
+	metricNames []string // metrics this panel shows, for linking alerts to it
+}
+
+// panelGroup is declared by a PanelGroup, or Dashboard, annotation in the source, e.g. `(name = Payments, metrics = "refunds payouts")`
+type panelGroup struct {
+	name    string
+	metrics []string
+}
+
+func (lo LayoutOptions) rows() string {
+	if lo.Rows == "" {
+		return RowsByMetricType
+	}
+	return lo.Rows
+}
+
+func (lo LayoutOptions) panelSize(panelType string) (GridPos, error) {
+	for k, v := range keyValuePairs(lo.PanelSizes) {
+		if k != panelType {
+			continue
+		}
+
+		parts := strings.Split(strings.ToLower(v), "x")
+		if len(parts) == 2 {
+			w, wErr := strconv.Atoi(strings.TrimSpace(parts[0]))
+			h, hErr := strconv.Atoi(strings.TrimSpace(parts[1]))
+			if wErr == nil && hErr == nil && w > 0 && w <= dashboardWidth && h > 0 {
+				return GridPos{W: w, H: h}, nil
+			}
+		}
+		return GridPos{}, fmt.Errorf("bad panel size %s=%s, expected WxH with W up to %d", k, v, dashboardWidth)
+	}
+
+	if size, ok := DefaultPanelSizes[panelType]; ok {
+		return size, nil
+	}
+	return defaultPanelSize, nil
+}
+
+// rowFor names the row a metric's panels belong in
+func (dg *DashboardGenerator) rowFor(m *metric, external bool) string {
+	switch dg.Layout.rows() {
+	case RowsByMetricType:
+		if external {
+			return "external"
+		}
+		return m.MetricType
+	case RowsByPackage:
+		if external {
+			return "External"
+		}
+		return m.PackagePath
+	case RowsByAnnotation:
+		if group := groupFor(dg.panelGroups, m); group != "" {
+			return group
+		}
+		return "Other"
+	}
+	return ""
+}
+
+// rowOrder lists the rows in display order
+func (dg *DashboardGenerator) rowOrder(entries []layoutEntry) []string {
+	var order []string
+	seen := make(map[string]bool)
+
+	addRow := func(row string) {
+		if !seen[row] {
+			seen[row] = true
+			order = append(order, row)
+		}
+	}
+
+	addRow(redRow)
+
+	switch dg.Layout.rows() {
+	case RowsByMetricType:
+		for _, each := range metricTypeRows {
+			addRow(each.metricType)
+		}
+	case RowsByAnnotation:
+		for _, each := range dg.panelGroups {
+			addRow(each.name)
+		}
+	}
+
+	// Anything else in order of first appearance
+	for _, each := range entries {
+		addRow(each.row)
+	}
+
+	return order
+}
+
+func rowTitle(rows string, row string) string {
+	if rows == RowsByMetricType {
+		for _, each := range metricTypeRows {
+			if each.metricType == row {
+				return each.title
+			}
+		}
+	}
+	return row
+}
+
+// layoutPanels arranges the panels left to right, top to bottom, wrapping whenever the next panel would not fit, and
+// starting each row on a new line. Panels are only ever placed based on their own sizes and order, so the layout is
+// the same on every run.
+func (dg *DashboardGenerator) layoutPanels(dashboard *Dashboard, entries []layoutEntry) error {
+	rows := dg.Layout.rows()
+
+	switch rows {
+	case RowsByMetricType, RowsByPackage, RowsByAnnotation, NoRows:
+	default:
+		return fmt.Errorf("unsupported dashboard rows %q, expected %s, %s, %s or %s", rows, RowsByMetricType, RowsByPackage, RowsByAnnotation, NoRows)
+	}
+
+	byRow := make(map[string][]layoutEntry)
+	for _, each := range entries {
+		byRow[each.row] = append(byRow[each.row], each)
+	}
+
+	cursor := layoutCursor{}
+
+	for _, row := range dg.rowOrder(entries) {
+		rowEntries := byRow[row]
+		if len(rowEntries) == 0 {
+			continue
+		}
+
+		var rowPanel *Panel
+		collapsed := dg.Layout.CollapseRows && row != redRow
+
+		if rows != NoRows {
+			rowPanel = &Panel{Type: "row", Title: rowTitle(rows, row), GridPos: cursor.place(rowSize), Collapsed: &collapsed}
+			dg.addPanel(dashboard, rowPanel, "row/"+row)
+		}
+
+		for _, each := range rowEntries {
+			size, err := dg.Layout.panelSize(each.panel.Type)
+			if err != nil {
+				return err
+			}
+
+			each.panel.GridPos = cursor.place(size)
+
+			if rowPanel != nil && collapsed {
+				dg.nestPanel(rowPanel, each.panel, each.key, each.metricNames...)
+			} else {
+				dg.addPanel(dashboard, each.panel, each.key, each.metricNames...)
+			}
+		}
+
+		cursor.newLine()
+	}
+
+	return nil
+}
+
+var rowSize = GridPos{W: dashboardWidth, H: 1}
+
+// layoutCursor tracks where the next panel goes
+type layoutCursor struct {
+	x, y, lineHeight int
+}
+
+// place puts a panel of the given size next on the current line, or on a new line if it doesn't fit. Rows always
+// get a line to themselves.
+func (c *layoutCursor) place(size GridPos) GridPos {
+	if c.x+size.W > dashboardWidth || size.W == dashboardWidth {
+		c.newLine()
+	}
+
+	pos := GridPos{H: size.H, W: size.W, X: c.x, Y: c.y}
+
+	c.x += size.W
+	if size.H > c.lineHeight {
+		c.lineHeight = size.H
+	}
+
+	return pos
+}
+
+func (c *layoutCursor) newLine() {
+	c.x = 0
+	c.y += c.lineHeight
+	c.lineHeight = 0
+}
+
+func (dg *DashboardGenerator) processDashboardAnnotations(commentGroup *ast.CommentGroup) {
+	if commentGroup == nil {
+		return
+	}
+
+	for _, comment := range commentGroup.List {
+		for _, eachLine := range strings.Split(strings.ReplaceAll(comment.Text, "\r\n", "\n"), "\n") {
+			if strings.Contains(eachLine, "@PanelGroup") {
+				dg.panelGroups = append(dg.panelGroups, parseGroup("Panel group", eachLine))
+			} else if strings.Contains(eachLine, "@Dashboard(") {
+				dg.dashboardGroups = append(dg.dashboardGroups, parseGroup("Dashboard", eachLine))
+			} else if strings.Contains(eachLine, "@REDMetrics(") {
+				props := make(map[string]string)
+				parsePayload(eachLine, props)
+				dg.redMetrics = &redMetrics{requests: props["requests"], errors: props["errors"], duration: props["duration"]}
+			}
+		}
+	}
+}
+
+func parseGroup(kind string, line string) panelGroup {
+	props := make(map[string]string)
+	parsePayload(line, props)
+
+	if props["name"] == "" {
+		log.Fatalf("%s has no name: %s", kind, line)
+	}
+
+	// Names are separated by spaces or `|`, as commas would split the payload
+	names := strings.FieldsFunc(props["metrics"], func(r rune) bool { return r == ' ' || r == '|' })
+	return panelGroup{name: props["name"], metrics: names}
+}
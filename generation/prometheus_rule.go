@@ -0,0 +1,137 @@
+package generation
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v2"
+)
+
+const PrometheusRuleApiVersion = "monitoring.coreos.com/v1"
+const PrometheusRuleKind = "PrometheusRule"
+
+const DefaultPrometheusRuleHelmValuesKey = "prometheusRule"
+
+// PrometheusRuleOptions configures the full `PrometheusRule` custom resource written for PrometheusRuleResourceFormat
+type PrometheusRuleOptions struct {
+	Name        string
+	Namespace   string
+	Labels      []string // key=value, e.g. to match the Prometheus `ruleSelector`
+	Annotations []string // key=value
+
+	HelmTemplate  bool   // Emit a Helm template that takes metadata from the chart values
+	HelmValuesKey string // Defaults to DefaultPrometheusRuleHelmValuesKey
+}
+
+// PrometheusRuleResource https://github.com/prometheus-operator/prometheus-operator/blob/master/Documentation/api.md#prometheusrule
+type PrometheusRuleResource struct {
+	ApiVersion string                      `yaml:"apiVersion"`
+	Kind       string                      `yaml:"kind"`
+	Metadata   ObjectMeta                  `yaml:"metadata"`
+	Spec       PrometheusOperatorRulesSpec `yaml:"spec"`
+}
+
+type ObjectMeta struct {
+	Name        string            `yaml:"name"`
+	Namespace   string            `yaml:"namespace,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+const PrometheusRuleHelmTemplate = `apiVersion: {{ .ApiVersion }}
+kind: {{ .Kind }}
+metadata:
+  name: {{ "{{" }} .Values.{{ .ValuesKey }}.name | default {{ printf "%q" .Name }} {{ "}}" }}
+  namespace: {{ "{{" }} .Values.{{ .ValuesKey }}.namespace | default {{ if .Namespace }}{{ printf "%q" .Namespace }}{{ else }}.Release.Namespace{{ end }} {{ "}}" }}
+  labels:
+{{- range $k, $v := .Labels }}
+    {{ $k }}: {{ printf "%q" $v }}
+{{- end }}
+    {{ "{{-" }} with .Values.{{ .ValuesKey }}.labels {{ "}}" }}
+    {{ "{{-" }} toYaml . | nindent 4 {{ "}}" }}
+    {{ "{{-" }} end {{ "}}" }}
+  annotations:
+{{- range $k, $v := .Annotations }}
+    {{ $k }}: {{ printf "%q" $v }}
+{{- end }}
+    {{ "{{-" }} with .Values.{{ .ValuesKey }}.annotations {{ "}}" }}
+    {{ "{{-" }} toYaml . | nindent 4 {{ "}}" }}
+    {{ "{{-" }} end {{ "}}" }}
+spec:
+{{ .Spec }}`
+
+type prometheusRuleHelmData struct {
+	ObjectMeta
+	ApiVersion string
+	Kind       string
+	ValuesKey  string
+	Spec       string
+}
+
+// Anything that looks like a Helm action in the rules themselves (e.g. `{{ $value }}` in an annotation) must be escaped
+var helmDelimitersEscaper = strings.NewReplacer("{{", `{{"{{"}}`, "}}", `{{"}}"}}`)
+
+var invalidResourceNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+func (opts PrometheusRuleOptions) render(displayPrefix string, spec PrometheusOperatorRulesSpec) ([]byte, error) {
+	meta := ObjectMeta{Name: opts.Name, Namespace: opts.Namespace}
+	if meta.Name == "" {
+		meta.Name = kubernetesResourceName(displayPrefix + "-alert-rules")
+	}
+	if len(opts.Labels) > 0 {
+		meta.Labels = keyValuePairs(opts.Labels)
+	}
+	if len(opts.Annotations) > 0 {
+		meta.Annotations = keyValuePairs(opts.Annotations)
+	}
+
+	if !opts.HelmTemplate {
+		return yaml.Marshal(&PrometheusRuleResource{ApiVersion: PrometheusRuleApiVersion, Kind: PrometheusRuleKind, Metadata: meta, Spec: spec})
+	}
+
+	specData, err := yaml.Marshal(&spec)
+	if err != nil {
+		return nil, err
+	}
+
+	valuesKey := opts.HelmValuesKey
+	if valuesKey == "" {
+		valuesKey = DefaultPrometheusRuleHelmValuesKey
+	}
+
+	tmpl, err := template.New("prometheusRule").Parse(PrometheusRuleHelmTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.Buffer{}
+	err = tmpl.Execute(&buf, &prometheusRuleHelmData{
+		ObjectMeta: meta,
+		ApiVersion: PrometheusRuleApiVersion,
+		Kind:       PrometheusRuleKind,
+		ValuesKey:  valuesKey,
+		Spec:       indentLines(helmDelimitersEscaper.Replace(string(specData)), "  "),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("helm template execution: %v", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func kubernetesResourceName(name string) string {
+	return strings.Trim(invalidResourceNameChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+func indentLines(text string, indent string) string {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	for i, each := range lines {
+		if each != "" {
+			lines[i] = indent + each
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,239 @@
+package generation
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultRulerRulesPath is the Mimir/Cortex ruler configuration API. Thanos and older Cortex setups may differ.
+const DefaultRulerRulesPath = "/prometheus/config/v1/rules"
+
+const RulerTenantHeader = "X-Scope-OrgID"
+
+// RulerOptions configures the namespace file written for RulerNamespaceFormat
+type RulerOptions struct {
+	Namespace string // Defaults to the display prefix, as a resource name
+}
+
+// RulerNamespace is a namespace of rule groups, as per the mimirtool/cortextool rules file format
+type RulerNamespace struct {
+	Namespace string                              `yaml:"namespace"`
+	Groups    []PrometheusOperatorAlertRulesGroup `yaml:"groups"`
+}
+
+func (opts RulerOptions) namespace(displayPrefix string, groups []PrometheusOperatorAlertRulesGroup) RulerNamespace {
+	namespace := opts.Namespace
+	if namespace == "" {
+		namespace = kubernetesResourceName(displayPrefix)
+	}
+	return RulerNamespace{Namespace: namespace, Groups: groups}
+}
+
+func ReadRulerNamespace(filePath string) (RulerNamespace, error) {
+	var namespace RulerNamespace
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return namespace, err
+	}
+
+	if err := yaml.Unmarshal(data, &namespace); err != nil {
+		return namespace, fmt.Errorf("bad ruler namespace file %s: %v", filePath, err)
+	}
+
+	if namespace.Namespace == "" {
+		return namespace, fmt.Errorf("no namespace in ruler namespace file %s", filePath)
+	}
+
+	return namespace, nil
+}
+
+// RulerClient talks to the ruler HTTP API on behalf of a single tenant
+type RulerClient struct {
+	Address     string
+	Tenant      string // Sent as X-Scope-OrgID where set
+	BearerToken string
+	RulesPath   string // Defaults to DefaultRulerRulesPath
+	HttpClient  *http.Client
+}
+
+func (c *RulerClient) ListRuleGroups() (map[string][]PrometheusOperatorAlertRulesGroup, error) {
+	namespaces := make(map[string][]PrometheusOperatorAlertRulesGroup)
+
+	body, status, err := c.do(http.MethodGet, "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// No rules at all for this tenant
+	if status == http.StatusNotFound {
+		return namespaces, nil
+	}
+
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("ruler list failed: %d %s", status, body)
+	}
+
+	if err := yaml.Unmarshal(body, &namespaces); err != nil {
+		return nil, fmt.Errorf("bad ruler response: %v", err)
+	}
+
+	return namespaces, nil
+}
+
+func (c *RulerClient) SetRuleGroup(namespace string, group PrometheusOperatorAlertRulesGroup) error {
+	data, err := yaml.Marshal(&group)
+	if err != nil {
+		return err
+	}
+
+	body, status, err := c.do(http.MethodPost, "/"+url.PathEscape(namespace), data)
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusAccepted && status != http.StatusOK {
+		return fmt.Errorf("ruler update of %s/%s failed: %d %s", namespace, group.Name, status, body)
+	}
+
+	return nil
+}
+
+func (c *RulerClient) DeleteRuleGroup(namespace string, groupName string) error {
+	body, status, err := c.do(http.MethodDelete, "/"+url.PathEscape(namespace)+"/"+url.PathEscape(groupName), nil)
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusAccepted && status != http.StatusOK && status != http.StatusNotFound {
+		return fmt.Errorf("ruler delete of %s/%s failed: %d %s", namespace, groupName, status, body)
+	}
+
+	return nil
+}
+
+func (c *RulerClient) do(method string, subPath string, payload []byte) ([]byte, int, error) {
+	rulesPath := c.RulesPath
+	if rulesPath == "" {
+		rulesPath = DefaultRulerRulesPath
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.Address, "/")+rulesPath+subPath, bytes.NewReader(payload))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/yaml")
+	}
+	if c.Tenant != "" {
+		req.Header.Set(RulerTenantHeader, c.Tenant)
+	}
+	if c.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.BearerToken)
+	}
+
+	httpClient := c.HttpClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("ruler request failed: %v", err)
+	}
+
+	//goland:noinspection GoUnhandledErrorResult
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	return body, resp.StatusCode, err
+}
+
+const (
+	RuleGroupCreated = "create"
+	RuleGroupUpdated = "update"
+	RuleGroupDeleted = "delete"
+)
+
+type RuleGroupChange struct {
+	Action    string
+	Namespace string
+	Group     string
+}
+
+func (c RuleGroupChange) String() string {
+	return fmt.Sprintf("%s %s/%s", c.Action, c.Namespace, c.Group)
+}
+
+// SyncRulerNamespaces brings the ruler in line with the desired namespaces, returning the changes made (or, for a dry run,
+// those that would be made). Groups are only ever deleted from namespaces that are being synced.
+func SyncRulerNamespaces(client *RulerClient, desired []RulerNamespace, dryRun bool) ([]RuleGroupChange, error) {
+	existing, err := client.ListRuleGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []RuleGroupChange
+
+	for _, eachNamespace := range desired {
+		current := make(map[string]PrometheusOperatorAlertRulesGroup)
+		for _, each := range existing[eachNamespace.Namespace] {
+			current[each.Name] = each
+		}
+
+		wanted := make(map[string]bool)
+
+		for _, eachGroup := range eachNamespace.Groups {
+			wanted[eachGroup.Name] = true
+
+			if currentGroup, ok := current[eachGroup.Name]; !ok {
+				changes = append(changes, RuleGroupChange{Action: RuleGroupCreated, Namespace: eachNamespace.Namespace, Group: eachGroup.Name})
+			} else if !sameRuleGroup(currentGroup, eachGroup) {
+				changes = append(changes, RuleGroupChange{Action: RuleGroupUpdated, Namespace: eachNamespace.Namespace, Group: eachGroup.Name})
+			} else {
+				continue
+			}
+
+			if !dryRun {
+				if err := client.SetRuleGroup(eachNamespace.Namespace, eachGroup); err != nil {
+					return changes, err
+				}
+			}
+		}
+
+		var obsolete []string
+		for name := range current {
+			if !wanted[name] {
+				obsolete = append(obsolete, name)
+			}
+		}
+		sort.Strings(obsolete)
+
+		for _, name := range obsolete {
+			changes = append(changes, RuleGroupChange{Action: RuleGroupDeleted, Namespace: eachNamespace.Namespace, Group: name})
+
+			if !dryRun {
+				if err := client.DeleteRuleGroup(eachNamespace.Namespace, name); err != nil {
+					return changes, err
+				}
+			}
+		}
+	}
+
+	return changes, nil
+}
+
+func sameRuleGroup(a PrometheusOperatorAlertRulesGroup, b PrometheusOperatorAlertRulesGroup) bool {
+	aData, aErr := yaml.Marshal(&a)
+	bData, bErr := yaml.Marshal(&b)
+	return aErr == nil && bErr == nil && bytes.Equal(aData, bData)
+}
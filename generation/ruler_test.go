@@ -0,0 +1,154 @@
+package generation
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/tools/go/packages"
+	"gopkg.in/yaml.v2"
+)
+
+// Minimal stand-in for the Mimir/Cortex ruler configuration API, for a single tenant
+type fakeRuler struct {
+	sync.Mutex
+	tenant     string
+	namespaces map[string][]PrometheusOperatorAlertRulesGroup
+	mutations  int
+}
+
+func (f *fakeRuler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	if r.Header.Get(RulerTenantHeader) != f.tenant {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var parts []string
+	for _, each := range strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), DefaultRulerRulesPath), "/")[1:] {
+		part, _ := url.PathUnescape(each)
+		parts = append(parts, part)
+	}
+
+	switch {
+	case r.Method == http.MethodGet && len(parts) == 0:
+		data, _ := yaml.Marshal(f.namespaces)
+		_, _ = w.Write(data)
+	case r.Method == http.MethodPost && len(parts) == 1:
+		var group PrometheusOperatorAlertRulesGroup
+		body, _ := io.ReadAll(r.Body)
+		if err := yaml.Unmarshal(body, &group); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		groups := f.namespaces[parts[0]]
+		for i, each := range groups {
+			if each.Name == group.Name {
+				groups = append(groups[:i], groups[i+1:]...)
+				break
+			}
+		}
+		f.namespaces[parts[0]] = append(groups, group)
+		f.mutations++
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodDelete && len(parts) == 2:
+		groups := f.namespaces[parts[0]]
+		for i, each := range groups {
+			if each.Name == parts[1] {
+				f.namespaces[parts[0]] = append(groups[:i], groups[i+1:]...)
+				f.mutations++
+				w.WriteHeader(http.StatusAccepted)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestRulerSync(t *testing.T) {
+	rule := PrometheusOperatorAlertRuleOutput{Alert: "AppCalcError", Expr: "sum(rate(app_errors{error_type='e'}[1m])) > 0", For: "10s", Labels: map[string]string{"team": "myTeam"}, Annotations: map[string]string{"summary": "Calculation error"}}
+	staleRule := rule
+	staleRule.For = "1m"
+
+	ruler := &fakeRuler{tenant: "team-a", namespaces: map[string][]PrometheusOperatorAlertRulesGroup{
+		"app": {
+			{Name: "App auto-generated alerts", Rules: []PrometheusOperatorAlertRuleOutput{staleRule}},
+			{Name: "Obsolete alerts", Rules: []PrometheusOperatorAlertRuleOutput{rule}},
+		},
+		"other": {{Name: "Not ours", Rules: []PrometheusOperatorAlertRuleOutput{rule}}},
+	}}
+
+	server := httptest.NewServer(ruler)
+	defer server.Close()
+
+	client := &RulerClient{Address: server.URL, Tenant: "team-a"}
+	desired := []RulerNamespace{{Namespace: "app", Groups: []PrometheusOperatorAlertRulesGroup{
+		{Name: "App auto-generated alerts", Rules: []PrometheusOperatorAlertRuleOutput{rule}},
+		{Name: "App/new alerts", Rules: []PrometheusOperatorAlertRuleOutput{rule}},
+	}}}
+
+	expectedChanges := []RuleGroupChange{
+		{Action: RuleGroupUpdated, Namespace: "app", Group: "App auto-generated alerts"},
+		{Action: RuleGroupCreated, Namespace: "app", Group: "App/new alerts"},
+		{Action: RuleGroupDeleted, Namespace: "app", Group: "Obsolete alerts"},
+	}
+
+	changes, err := SyncRulerNamespaces(client, desired, true)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedChanges, changes)
+	assert.Equal(t, 0, ruler.mutations)
+
+	changes, err = SyncRulerNamespaces(client, desired, false)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedChanges, changes)
+	assert.Equal(t, 3, ruler.mutations)
+
+	assert.Equal(t, desired[0].Groups, ruler.namespaces["app"])
+	assert.Len(t, ruler.namespaces["other"], 1)
+
+	changes, err = SyncRulerNamespaces(client, desired, false)
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+
+	_, err = SyncRulerNamespaces(&RulerClient{Address: server.URL, Tenant: "team-b"}, desired, true)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "ruler list failed: 401")
+}
+
+func TestRulerNamespaceGeneration(t *testing.T) {
+	loadedPkgs, err := packages.Load(&scanConf, "")
+	assert.NoError(t, err)
+
+	generator := &DashboardGenerator{}
+	_, _ = generator.DiscoverMetrics(loadedPkgs)
+
+	tempFile, err := os.CreateTemp("", "x*.yaml")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//goland:noinspection GoUnhandledErrorResult
+	defer os.Remove(tempFile.Name())
+
+	_, err = generator.GenerateAlertRules(tempFile.Name(), OutputOptions{AlertRuleFormat: RulerNamespaceFormat})
+	assert.NoError(t, err)
+
+	namespace, err := ReadRulerNamespace(tempFile.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, "application", namespace.Namespace)
+	assert.Equal(t, "Application auto-generated alerts", namespace.Groups[0].Name)
+	assert.Equal(t, "ApplicationCalcError", namespace.Groups[0].Rules[0].Alert)
+	assert.Equal(t, "10s", namespace.Groups[0].Rules[0].For)
+}
@@ -0,0 +1,18 @@
+package e
+
+import (
+	promenade "github.com/poblish/promenade/api"
+)
+
+/*
+	@AlertDefaults(displayPrefix = Payments, severity = warning, team = payments)
+	@ZeroToleranceErrorAlertRule(name = refundError, errorLabel="refund", severity = pager, summary = Refund failed)
+	@ElevatedErrorRateAlertRule(name = refundProblems, errorLabel="refund", timeRange=10m, ratePerSecondThreshold=0.5, summary = More refund errors)
+	@ElevatedErrorRateAlertRule(name = ledgerProblems, errorLabel="ledger", ratePerSecondThreshold=1, team = ledger, summary = Ledger errors)
+*/
+//goland:noinspection GoUnusedFunction
+func unused() { //nolint:unused,deadcode // Is used!!
+	metrics := promenade.NewMetrics(promenade.MetricOpts{MetricNamePrefix: "payments"})
+	metrics.Error("refund")
+	metrics.Error("ledger")
+}
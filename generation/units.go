@@ -0,0 +1,119 @@
+package generation
+
+import (
+	"go/ast"
+	"log"
+	"strings"
+)
+
+const defaultUnit = "short"
+
+// Prometheus base unit suffixes, and their Grafana units
+// https://prometheus.io/docs/practices/naming/#base-units
+var unitSuffixes = []struct {
+	suffix string
+	unit   string
+}{
+	{"_seconds", "s"},
+	{"_milliseconds", "ms"},
+	{"_microseconds", "µs"},
+	{"_nanoseconds", "ns"},
+	{"_bytes", "bytes"},
+	{"_ratio", "percentunit"},
+	{"_percent", "percent"},
+	{"_celsius", "celsius"},
+	{"_meters", "lengthm"},
+	{"_volts", "volt"},
+	{"_amperes", "amp"},
+	{"_joules", "joule"},
+	{"_hertz", "hertz"},
+}
+
+// Friendlier names that may be used for an annotated unit, alongside any Grafana unit id
+var unitAliases = map[string]string{
+	"seconds":      "s",
+	"milliseconds": "ms",
+	"microseconds": "µs",
+	"nanoseconds":  "ns",
+	"ratio":        "percentunit",
+	"count":        defaultUnit,
+}
+
+// Grafana units for a per-second rate, by the unit of what's counted. Seconds per second is the share of time spent,
+// e.g. busy. Anything else is shown per second with a custom suffix.
+var rateUnits = map[string]string{
+	defaultUnit: "cps",
+	"bytes":     "Bps",
+	"decbytes":  "Bps",
+	"s":         "percentunit",
+	"ms":        "suffix: ms/s",
+	"µs":        "suffix: µs/s",
+	"ns":        "suffix: ns/s",
+	"joule":     "watt",
+	"lengthm":   "velocityms",
+}
+
+// Promenade constructors that imply a unit
+var constructorUnits = map[string]string{
+	"Timer":                    "s",
+	"TimerWithLabel":           "s",
+	"HistogramForResponseTime": "s",
+}
+
+// unit is the annotated unit for the metric, then any implied by its constructor, then any from its name
+func (dg *DashboardGenerator) unit(m *metric) string {
+	for _, name := range []string{m.PanelTitle, m.normalisedMetricName, m.FullMetricName} {
+		if unit, ok := dg.metricUnits[name]; ok {
+			return unit
+		}
+	}
+
+	if unit, ok := constructorUnits[m.metricCall]; ok {
+		return unit
+	}
+
+	name := strings.TrimSuffix(strings.ToLower(m.FullMetricName), "_total")
+	for _, each := range unitSuffixes {
+		if strings.HasSuffix(name, each.suffix) {
+			return each.unit
+		}
+	}
+
+	return defaultUnit
+}
+
+// rateUnit is the unit for a per-second rate of the metric
+func (dg *DashboardGenerator) rateUnit(m *metric) string {
+	unit := dg.unit(m)
+	if rateUnit, ok := rateUnits[unit]; ok {
+		return rateUnit
+	}
+	return "suffix: " + unit + "/s"
+}
+
+// processMetricAnnotations picks up metric properties, e.g. `@Metric(name = payload, unit = bytes)`
+func (dg *DashboardGenerator) processMetricAnnotations(commentGroup *ast.CommentGroup) {
+	if commentGroup == nil {
+		return
+	}
+
+	for _, comment := range commentGroup.List {
+		for _, eachLine := range strings.Split(strings.ReplaceAll(comment.Text, "\r\n", "\n"), "\n") {
+			if strings.Contains(eachLine, "@Metric(") {
+				props := make(map[string]string)
+				parsePayload(eachLine, props)
+
+				if props["name"] == "" {
+					log.Fatalf("Metric annotation has no name: %s", eachLine)
+				}
+
+				if unit := props["unit"]; unit != "" {
+					if alias, ok := unitAliases[unit]; ok {
+						unit = alias
+					}
+					dg.metricUnits[props["name"]] = unit
+				}
+			}
+		}
+	}
+}
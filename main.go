@@ -21,14 +21,58 @@ var rulesOutputFormat string
 var dashboardOutputPath string
 var dashboardUid string
 var dashboardTitle string
+var dashboardSchemaVersion int
+var dashboardRows string
+var dashboardCollapseRows bool
+var dashboardPanelSizes extraLabels
+var dashboardLabelVariables bool
+var dashboardTemplate string
+var dashboardMerge bool
+var dashboardSplit string
+var dashboardFolder string
+var dashboardREDRow bool
+var dashboardRuntimeRow bool
+var dashboardTags extraLabels
+var dashboardDescription string
+var dashboardTimeFrom string
+var dashboardTimeTo string
+var dashboardRefresh string
+var dashboardTimezone string
+var dashboardReadOnly bool
+var dashboardLinks extraLabels
+var dashboardFormat string
+var dashboardResourceName string
+var dashboardResourceNamespace string
+var dashboardResourceLabels extraLabels
+var dashboardResourceAnnotations extraLabels
+var dashboardHelmTemplate bool
 var metricsLabelsPath string
 var sourcePath string
 var defaultMetricsPrefix string
 var alertExtraLabels extraLabels
+var prometheusRuleName string
+var prometheusRuleNamespace string
+var prometheusRuleLabels extraLabels
+var prometheusRuleAnnotations extraLabels
+var prometheusRuleHelmTemplate bool
+var grafanaAlertFolder string
+var grafanaDatasourceUid string
+var tempoDatasourceUid string
+var lokiDatasourceUid string
+var grafanaUrl string
+var rulerNamespace string
+var alertmanagerOutputPath string
+var alertPolicyPath string
 
 var alertManagerOutputFormat = "alertManager"
+var prometheusRuleOutputFormat = "prometheusRule"
+var grafanaAlertingOutputFormat = "grafana"
+var rulerOutputFormat = "ruler"
 var defaultRulesOutputFileName = "alert_rules.yaml"
 var defaultGrafanaDashboardFileName = "grafana_dashboard.json"
+var defaultDashboardResourceFileName = "grafana-dashboard.yaml"
+var defaultPrometheusRuleTemplateFileName = "prometheus-rule.yaml"
+var defaultRulerOutputFileName = "ruler_rules.yaml"
 
 func main() {
 	currentDir, err := os.Getwd()
@@ -46,6 +90,16 @@ func main() {
 		}
 	}
 
+	if len(os.Args) > 2 && os.Args[1] == "rules" && os.Args[2] == "sync" {
+		rulesSync(os.Args[3:], state)
+		return
+	}
+
+	if len(os.Args) > 2 && os.Args[1] == "dashboard" && os.Args[2] == "push" {
+		dashboardPush(os.Args[3:], state)
+		return
+	}
+
 	flag.Var(&packageFlags, "pkg", "Packages to scan")
 	flag.StringVar(&sourcePath, "sourcePath", "", "Source path")
 	flag.StringVar(&rulesOutputPath, "rulesOutputPath", "", "Rules output path")
@@ -53,27 +107,83 @@ func main() {
 	flag.StringVar(&dashboardOutputPath, "dashboardOutputPath", "", "Dashboard output path")
 	flag.StringVar(&dashboardUid, "dashboardUid", "", "Override default Dashboard id")
 	flag.StringVar(&dashboardTitle, "dashboardTitle", "", "Override default Dashboard title")
+	flag.IntVar(&dashboardSchemaVersion, "dashboardSchemaVersion", 0, "Grafana dashboard schemaVersion to target")
+	flag.StringVar(&dashboardRows, "dashboardRows", "", "Group dashboard panels into rows by: type, package, annotation or none")
+	flag.BoolVar(&dashboardCollapseRows, "dashboardCollapseRows", false, "Collapse dashboard rows")
+	flag.Var(&dashboardPanelSizes, "dashboardPanelSizes", "Dashboard panel sizes by type (type=WxH)")
+	flag.BoolVar(&dashboardLabelVariables, "dashboardLabelVariables", false, "Add a dashboard variable for each metric label")
+	flag.StringVar(&dashboardTemplate, "dashboardTemplate", "", "Path to a Go template to render the dashboard JSON with")
+	flag.StringVar(&dashboardSplit, "dashboardSplit", "", "Split the dashboard: none (default), package, annotation or overview")
+	flag.StringVar(&dashboardFolder, "dashboardFolder", "", "Grafana folder to provision the dashboards into")
+	flag.BoolVar(&dashboardREDRow, "dashboardREDRow", false, "Start the dashboard with request rate, error ratio and p99 latency")
+	flag.BoolVar(&dashboardRuntimeRow, "dashboardRuntimeRow", false, "End the dashboard with goroutines, GC pause, heap, CPU and open file descriptors")
+	flag.Var(&dashboardTags, "dashboardTags", "Dashboard tag, may be repeated")
+	flag.StringVar(&dashboardDescription, "dashboardDescription", "", "Dashboard description")
+	flag.StringVar(&dashboardTimeFrom, "dashboardTimeFrom", "", "Start of the dashboard's default time range, e.g. now-6h (default now/d)")
+	flag.StringVar(&dashboardTimeTo, "dashboardTimeTo", "", "End of the dashboard's default time range (default now)")
+	flag.StringVar(&dashboardRefresh, "dashboardRefresh", "", "Dashboard auto-refresh interval, e.g. 30s")
+	flag.StringVar(&dashboardTimezone, "dashboardTimezone", "", "Dashboard timezone: browser, utc or an IANA name")
+	flag.BoolVar(&dashboardReadOnly, "dashboardReadOnly", false, "Make the dashboard read-only")
+	flag.Var(&dashboardLinks, "dashboardLinks", "Dashboard link (kind=url, kind being runbook, repo, dashboard or link, or dashboard=tag), may be repeated")
+	flag.StringVar(&dashboardFormat, "dashboardFormat", "", "Write dashboards as: json (default), configmap for the Grafana sidecar, or grafanadashboard for grafana-operator")
+	flag.StringVar(&dashboardResourceName, "dashboardResourceName", "", "Dashboard ConfigMap or GrafanaDashboard resource name")
+	flag.StringVar(&dashboardResourceNamespace, "dashboardResourceNamespace", "", "Dashboard ConfigMap or GrafanaDashboard resource namespace")
+	flag.Var(&dashboardResourceLabels, "dashboardResourceLabels", "Dashboard ConfigMap or GrafanaDashboard resource labels (key=value)")
+	flag.Var(&dashboardResourceAnnotations, "dashboardResourceAnnotations", "Dashboard ConfigMap or GrafanaDashboard resource annotations (key=value)")
+	flag.BoolVar(&dashboardHelmTemplate, "dashboardHelmTemplate", false, "Write the dashboard resource as a Helm template")
+	flag.BoolVar(&dashboardMerge, "dashboardMerge", false, "Update the generated panels in the existing dashboard, keeping any added or edited by hand")
 	flag.StringVar(&metricsLabelsPath, "metricsLabelsPath", "", "Metrics labels path")
 	flag.Var(&alertExtraLabels, "alertExtraLabels", "Extra alert labels (key=value)")
 	flag.StringVar(&defaultMetricsPrefix, "defaultMetricsPrefix", "", "Metrics prefix fallback/default")
+	flag.StringVar(&prometheusRuleName, "prometheusRuleName", "", "PrometheusRule resource name")
+	flag.StringVar(&prometheusRuleNamespace, "prometheusRuleNamespace", "", "PrometheusRule resource namespace")
+	flag.Var(&prometheusRuleLabels, "prometheusRuleLabels", "PrometheusRule resource labels (key=value)")
+	flag.Var(&prometheusRuleAnnotations, "prometheusRuleAnnotations", "PrometheusRule resource annotations (key=value)")
+	flag.BoolVar(&prometheusRuleHelmTemplate, "prometheusRuleHelmTemplate", false, "Write the PrometheusRule resource as a Helm template")
+	flag.StringVar(&grafanaAlertFolder, "grafanaAlertFolder", "", "Grafana alerting folder")
+	flag.StringVar(&grafanaDatasourceUid, "grafanaDatasourceUid", "", "Grafana Prometheus datasource UID")
+	flag.StringVar(&tempoDatasourceUid, "tempoDatasourceUid", "", "Grafana Tempo datasource UID, for exemplars and links from timer and histogram panels to traces")
+	flag.StringVar(&lokiDatasourceUid, "lokiDatasourceUid", "", "Grafana Loki datasource UID, for links from panels to logs")
+	flag.StringVar(&grafanaUrl, "grafanaUrl", "", "Grafana base URL, for links between alerts and dashboard panels")
+	flag.StringVar(&rulerNamespace, "rulerNamespace", "", "Ruler namespace")
+	flag.StringVar(&alertPolicyPath, "alertPolicyPath", "", "Alert labels and annotations policy file")
+	flag.StringVar(&alertmanagerOutputPath, "alertmanagerOutputPath", "", "Alertmanager routing and inhibition config output path")
 	flag.Parse()
 
-	if rulesOutputPath == "" {
-		if state.GeneratedChartDir != "" {
-			rulesOutputPath = fmt.Sprintf("%s/includes/prometheus-rules/%s", state.GeneratedChartDir, defaultRulesOutputFileName)
+	if rulesOutputFormat == "" {
+		if state.RulesOutputFormat != "" {
+			rulesOutputFormat = state.RulesOutputFormat
 		} else {
-			rulesOutputPath = defaultRulesOutputFileName
+			rulesOutputFormat = alertManagerOutputFormat
 		}
 	}
 
-	if dashboardOutputPath == "" {
-		if state.GeneratedChartDir != "" {
-			dashboardOutputPath = fmt.Sprintf("%s/includes/dashboards/%s", state.GeneratedChartDir, defaultGrafanaDashboardFileName)
+	if !prometheusRuleHelmTemplate {
+		prometheusRuleHelmTemplate = state.PrometheusRuleHelmTemplate
+	}
+
+	if rulesOutputPath == "" {
+		if state.GeneratedChartDir != "" && rulesOutputFormat == prometheusRuleOutputFormat && prometheusRuleHelmTemplate {
+			rulesOutputPath = fmt.Sprintf("%s/templates/%s", state.GeneratedChartDir, defaultPrometheusRuleTemplateFileName)
 		} else {
-			dashboardOutputPath = defaultGrafanaDashboardFileName
+			rulesOutputPath = defaultRulesOutputPath(state, rulesOutputFormat)
 		}
 	}
 
+	if dashboardFormat == "" {
+		dashboardFormat = state.DashboardFormat
+	}
+
+	if !dashboardHelmTemplate {
+		dashboardHelmTemplate = state.DashboardHelmTemplate
+	}
+
+	if dashboardOutputPath == "" {
+		var chartTemplate bool
+		dashboardOutputPath, chartTemplate = defaultDashboardPath(state, dashboardFormat)
+		dashboardHelmTemplate = dashboardHelmTemplate || chartTemplate
+	}
+
 	if sourcePath == "" {
 		if state.SourcePath != "" {
 			sourcePath = state.SourcePath
@@ -86,14 +196,6 @@ func main() {
 		packageFlags = []string{state.DefaultPkg}
 	}
 
-	if rulesOutputFormat == "" {
-		if state.RulesOutputFormat != "" {
-			rulesOutputFormat = state.RulesOutputFormat
-		} else {
-			rulesOutputPath = alertManagerOutputFormat
-		}
-	}
-
 	if metricsLabelsPath == "" {
 		if state.MetricsLabelsPath != "" {
 			metricsLabelsPath = state.MetricsLabelsPath
@@ -112,16 +214,178 @@ func main() {
 		dashboardTitle = state.DashboardTitleOverride
 	}
 
+	if dashboardSchemaVersion == 0 {
+		dashboardSchemaVersion = state.DashboardSchemaVersion
+	}
+
+	if dashboardRows == "" {
+		dashboardRows = state.DashboardRows
+	}
+
+	if !dashboardCollapseRows {
+		dashboardCollapseRows = state.DashboardCollapseRows
+	}
+
+	if len(dashboardPanelSizes) == 0 {
+		dashboardPanelSizes = state.DashboardPanelSizes
+	}
+
+	if !dashboardLabelVariables {
+		dashboardLabelVariables = state.DashboardLabelVariables
+	}
+
+	if dashboardTemplate == "" {
+		dashboardTemplate = state.DashboardTemplate
+	}
+
+	if !dashboardMerge {
+		dashboardMerge = state.DashboardMerge
+	}
+
+	if dashboardSplit == "" {
+		dashboardSplit = state.DashboardSplit
+	}
+
+	if dashboardFolder == "" {
+		dashboardFolder = state.DashboardFolder
+	}
+
+	if !dashboardREDRow {
+		dashboardREDRow = state.DashboardREDRow
+	}
+
+	if !dashboardRuntimeRow {
+		dashboardRuntimeRow = state.DashboardRuntimeRow
+	}
+
+	if len(dashboardTags) == 0 {
+		dashboardTags = state.DashboardTags
+	}
+
+	if dashboardDescription == "" {
+		dashboardDescription = state.DashboardDescription
+	}
+
+	if dashboardTimeFrom == "" {
+		dashboardTimeFrom = state.DashboardTimeFrom
+	}
+
+	if dashboardTimeTo == "" {
+		dashboardTimeTo = state.DashboardTimeTo
+	}
+
+	if dashboardRefresh == "" {
+		dashboardRefresh = state.DashboardRefresh
+	}
+
+	if dashboardTimezone == "" {
+		dashboardTimezone = state.DashboardTimezone
+	}
+
+	if !dashboardReadOnly {
+		dashboardReadOnly = state.DashboardReadOnly
+	}
+
+	links := state.DashboardLinks
+	if len(dashboardLinks) > 0 {
+		links = nil
+		for _, each := range dashboardLinks {
+			link, err := generation.ParseDashboardLink(each)
+			if err != nil {
+				log.Fatalf("%s", err)
+			}
+			links = append(links, link)
+		}
+	}
+
 	if len(alertExtraLabels) == 0 {
 		alertExtraLabels = state.AlertExtraLabels
 	}
 
+	if prometheusRuleName == "" {
+		prometheusRuleName = state.PrometheusRuleName
+	}
+
+	if prometheusRuleNamespace == "" {
+		prometheusRuleNamespace = state.PrometheusRuleNamespace
+	}
+
+	if dashboardResourceName == "" {
+		dashboardResourceName = state.DashboardResourceName
+	}
+
+	if dashboardResourceNamespace == "" {
+		dashboardResourceNamespace = state.DashboardResourceNamespace
+	}
+
+	if len(dashboardResourceLabels) == 0 {
+		dashboardResourceLabels = state.DashboardResourceLabels
+	}
+
+	if len(dashboardResourceAnnotations) == 0 {
+		dashboardResourceAnnotations = state.DashboardResourceAnnotations
+	}
+
+	if len(prometheusRuleLabels) == 0 {
+		prometheusRuleLabels = state.PrometheusRuleLabels
+	}
+
+	if len(prometheusRuleAnnotations) == 0 {
+		prometheusRuleAnnotations = state.PrometheusRuleAnnotations
+	}
+
+	if grafanaAlertFolder == "" {
+		grafanaAlertFolder = state.GrafanaAlertFolder
+	}
+
+	if grafanaDatasourceUid == "" {
+		grafanaDatasourceUid = state.GrafanaDatasourceUid
+	}
+
+	if tempoDatasourceUid == "" {
+		tempoDatasourceUid = state.TempoDatasourceUid
+	}
+
+	if lokiDatasourceUid == "" {
+		lokiDatasourceUid = state.LokiDatasourceUid
+	}
+
+	if grafanaUrl == "" {
+		grafanaUrl = state.GrafanaUrl
+	}
+
+	if rulerNamespace == "" {
+		rulerNamespace = state.RulerNamespace
+	}
+
+	if alertmanagerOutputPath == "" {
+		alertmanagerOutputPath = state.AlertmanagerOutputPath
+	}
+
+	if alertPolicyPath == "" {
+		alertPolicyPath = state.AlertPolicyPath
+	}
+
+	var alertPolicy *generation.AlertPolicy
+	if alertPolicyPath != "" {
+		alertPolicy, err = generation.LoadAlertPolicy(alertPolicyPath)
+		if err != nil {
+			log.Fatalf("Could not load alert policy %s", err)
+		}
+	}
+
 	var alertRuleFormat int
 	switch rulesOutputFormat {
 	case alertManagerOutputFormat:
 		alertRuleFormat = generation.PrometheusAlertManagerFormat
 	case "operator":
 		alertRuleFormat = generation.PrometheusOperatorFormat
+	case prometheusRuleOutputFormat:
+		alertRuleFormat = generation.PrometheusRuleResourceFormat
+	case grafanaAlertingOutputFormat:
+		alertRuleFormat = generation.GrafanaAlertingFormat
+	case rulerOutputFormat:
+		alertRuleFormat = generation.RulerNamespaceFormat
 	default:
 		log.Fatalf("Unsupported rules output format %s", rulesOutputFormat)
 	}
@@ -141,31 +405,116 @@ func main() {
 		log.Fatalf("Could not load packages %s", err)
 	}
 
-	generator := &generation.DashboardGenerator{DefaultMetricsPrefix: defaultMetricsPrefix, DashboardUid: dashboardUid, DashboardTitle: dashboardTitle}
+	generator := &generation.DashboardGenerator{
+		DefaultMetricsPrefix: defaultMetricsPrefix,
+		DashboardUid:         dashboardUid,
+		DashboardTitle:       dashboardTitle,
+		SchemaVersion:        dashboardSchemaVersion,
+		DatasourceUid:        grafanaDatasourceUid,
+		GrafanaUrl:           grafanaUrl,
+		DashboardTemplate:    dashboardTemplate,
+		MergeDashboard:       dashboardMerge,
+		REDRow:               dashboardREDRow,
+		ExternalMetrics:      state.ExternalMetrics,
+		Runtime: generation.RuntimeOptions{Row: dashboardRuntimeRow, Job: state.RuntimeAlertJob,
+			GoroutineLeakThreshold: state.GoroutineLeakThreshold, FdExhaustionRatio: state.FdExhaustionRatio, ServiceDown: state.ServiceDownAlert,
+			ServiceDownSeverity: state.ServiceDownSeverity, ServiceDownTeam: state.ServiceDownTeam, ServiceDownDuration: state.ServiceDownDuration},
+		Metadata: generation.DashboardMetadataOptions{Description: dashboardDescription, TimeFrom: dashboardTimeFrom, TimeTo: dashboardTimeTo,
+			Refresh: dashboardRefresh, Timezone: dashboardTimezone, ReadOnly: dashboardReadOnly, Links: links},
+		DataLinks: generation.DataLinkOptions{TempoDatasourceUid: tempoDatasourceUid, LokiDatasourceUid: lokiDatasourceUid, TraceQuery: state.TraceQuery, LogQuery: state.LogQuery},
+		DashboardResource: generation.DashboardResourceOptions{Format: dashboardFormat, Name: dashboardResourceName, Namespace: dashboardResourceNamespace,
+			Labels: dashboardResourceLabels, Annotations: dashboardResourceAnnotations, FolderAnnotation: state.DashboardFolderAnnotation,
+			InstanceSelector: state.DashboardInstanceSelector, HelmTemplate: dashboardHelmTemplate},
+		Dashboards: generation.DashboardSplitOptions{By: dashboardSplit, Folder: dashboardFolder, ProvisionedPath: state.DashboardProvisionedPath},
+		Layout:     generation.LayoutOptions{Rows: dashboardRows, CollapseRows: dashboardCollapseRows, PanelSizes: dashboardPanelSizes},
+		Variables:  generation.VariableOptions{LabelVariables: dashboardLabelVariables},
+	}
 	metrics, err := generator.DiscoverMetrics(loadedPkgs)
 	if err != nil {
 		log.Fatalf("Metrics discovery failed %s", err)
 	}
 
+	// Dashboard first, so that alert rules can link to its panels
+	if len(metrics) > 0 || len(state.ExternalMetricNames) > 0 || len(state.ExternalMetrics) > 0 || dashboardRuntimeRow {
+		err = generator.GenerateGrafanaDashboard(dashboardOutputPath, metrics, dashboardTags, state.ExternalMetricNames)
+		if err != nil {
+			log.Fatalf("Generation failed %s", err)
+		}
+	}
+
 	if len(metrics) > 0 {
+		prometheusRuleOptions := generation.PrometheusRuleOptions{
+			Name:         prometheusRuleName,
+			Namespace:    prometheusRuleNamespace,
+			Labels:       prometheusRuleLabels,
+			Annotations:  prometheusRuleAnnotations,
+			HelmTemplate: prometheusRuleHelmTemplate,
+		}
+
+		grafanaAlertingOptions := generation.GrafanaAlertingOptions{
+			Folder:        grafanaAlertFolder,
+			RuleGroup:     state.GrafanaAlertRuleGroup,
+			Interval:      state.GrafanaAlertInterval,
+			DatasourceUid: grafanaDatasourceUid,
+		}
+
 		// FIXME Hardcoded name
-		alertMetrics, err := generator.GenerateAlertRules(rulesOutputPath, generation.OutputOptions{AlertRuleFormat: alertRuleFormat, ExtraLabels: alertExtraLabels})
+		alertMetrics, err := generator.GenerateAlertRules(rulesOutputPath, generation.OutputOptions{AlertRuleFormat: alertRuleFormat, ExtraLabels: alertExtraLabels, PrometheusRule: prometheusRuleOptions, GrafanaAlerting: grafanaAlertingOptions, Ruler: generation.RulerOptions{Namespace: rulerNamespace}, Policy: alertPolicy})
 		if err != nil {
 			log.Fatalf("Alert rule generation failed %s", err)
 		}
 
+		if alertmanagerOutputPath != "" {
+			alertmanagerOptions := generation.AlertmanagerOptions{
+				ExtraLabels:           alertExtraLabels,
+				GroupBy:               state.AlertmanagerGroupBy,
+				RepeatIntervals:       state.AlertmanagerRepeatIntervals,
+				DefaultRepeatInterval: state.AlertmanagerDefaultRepeatInterval,
+				ReceiverNameFormat:    state.AlertmanagerReceiverNameFormat,
+				SeverityOrder:         state.AlertmanagerSeverityOrder,
+				InhibitEqual:          state.AlertmanagerInhibitEqual,
+			}
+
+			if err := generator.GenerateAlertmanagerConfig(alertmanagerOutputPath, alertmanagerOptions); err != nil {
+				log.Fatalf("Alertmanager config generation failed %s", err)
+			}
+		}
+
 		if metricsLabelsPath != "" {
 			metricsOutput := generation.AlertMetricsOutput{AlertsCount: alertMetrics.Count, UniqueMetricsCount: len(metrics)}
 			metricsOutput.WriteToFile(metricsLabelsPath)
 		}
 	}
+}
 
-	if len(metrics) > 0 || len(state.ExternalMetricNames) > 0 {
-		err = generator.GenerateGrafanaDashboard(dashboardOutputPath, metrics, state.DashboardTags, state.ExternalMetricNames)
-		if err != nil {
-			log.Fatalf("Generation failed %s", err)
-		}
+func defaultRulesOutputPath(state BoulevardState, format string) string {
+	fileName := defaultRulesOutputFileName
+	if format == rulerOutputFormat {
+		fileName = defaultRulerOutputFileName
+	}
+
+	if state.GeneratedChartDir != "" {
+		return fmt.Sprintf("%s/includes/prometheus-rules/%s", state.GeneratedChartDir, fileName)
+	}
+	return fileName
+}
+
+// defaultDashboardPath is where the main dashboard is written in the format, and whether it's in a chart's templates
+func defaultDashboardPath(state BoulevardState, format string) (string, bool) {
+	if format == "" || format == generation.DashboardJsonFormat {
+		return defaultDashboardOutputPath(state), false
+	}
+	if state.GeneratedChartDir != "" {
+		return fmt.Sprintf("%s/templates/%s", state.GeneratedChartDir, defaultDashboardResourceFileName), true
+	}
+	return defaultDashboardResourceFileName, false
+}
+
+func defaultDashboardOutputPath(state BoulevardState) string {
+	if state.GeneratedChartDir != "" {
+		return fmt.Sprintf("%s/includes/dashboards/%s", state.GeneratedChartDir, defaultGrafanaDashboardFileName)
 	}
+	return defaultGrafanaDashboardFileName
 }
 
 func (i *packagesList) String() string {
@@ -187,15 +536,80 @@ func (i *extraLabels) Set(value string) error {
 }
 
 type BoulevardState struct {
-	SourcePath             string
-	GeneratedChartDir      string
-	DefaultPkg             string
-	DefaultMetricsPrefix   string
-	RulesOutputFormat      string
-	MetricsLabelsPath      string
-	DashboardUidOverride   string
-	DashboardTitleOverride string
-	DashboardTags          []string
-	AlertExtraLabels       []string
-	ExternalMetricNames    []string
+	SourcePath                   string
+	GeneratedChartDir            string
+	DefaultPkg                   string
+	DefaultMetricsPrefix         string
+	RulesOutputFormat            string
+	MetricsLabelsPath            string
+	DashboardUidOverride         string
+	DashboardTitleOverride       string
+	DashboardTags                []string
+	DashboardSchemaVersion       int
+	DashboardRows                string
+	DashboardCollapseRows        bool
+	DashboardPanelSizes          []string
+	DashboardLabelVariables      bool
+	DashboardTemplate            string
+	DashboardMerge               bool
+	DashboardSplit               string
+	DashboardFolder              string
+	DashboardProvisionedPath     string
+	DashboardREDRow              bool
+	DashboardRuntimeRow          bool
+	DashboardDescription         string
+	DashboardTimeFrom            string
+	DashboardTimeTo              string
+	DashboardRefresh             string
+	DashboardTimezone            string
+	DashboardReadOnly            bool
+	DashboardLinks               []generation.LinkOptions
+	DashboardFormat              string
+	DashboardResourceName        string
+	DashboardResourceNamespace   string
+	DashboardResourceLabels      []string
+	DashboardResourceAnnotations []string
+	DashboardFolderAnnotation    string   // Where the Grafana sidecar looks for the folder, defaults to grafana_folder
+	DashboardInstanceSelector    []string // Labels of the Grafana instances for grafana-operator, defaults to dashboards=grafana
+	DashboardHelmTemplate        bool
+	RuntimeAlertJob              string // Scrape job for the service down, goroutine leak and file descriptor alerts
+	GoroutineLeakThreshold       int
+	FdExhaustionRatio            float64
+	ServiceDownAlert             bool
+	ServiceDownSeverity          string
+	ServiceDownTeam              string
+	ServiceDownDuration          string
+	AlertExtraLabels             []string
+	ExternalMetricNames          []string // Deprecated, as each is just a jsonrpc2_server timer. Use ExternalMetrics.
+	ExternalMetrics              []generation.ExternalMetricFamily
+
+	PrometheusRuleName         string
+	PrometheusRuleNamespace    string
+	PrometheusRuleLabels       []string
+	PrometheusRuleAnnotations  []string
+	PrometheusRuleHelmTemplate bool
+
+	GrafanaAlertFolder    string
+	GrafanaAlertRuleGroup string
+	GrafanaAlertInterval  string
+	GrafanaDatasourceUid  string
+	GrafanaUrl            string
+	TempoDatasourceUid    string
+	LokiDatasourceUid     string
+	TraceQuery            string // TraceQL template for links to traces
+	LogQuery              string // LogQL template for links to logs
+
+	RulerNamespace string
+	RulerUrl       string
+	RulerTenant    string
+
+	AlertPolicyPath string
+
+	AlertmanagerOutputPath            string
+	AlertmanagerGroupBy               []string
+	AlertmanagerRepeatIntervals       []string
+	AlertmanagerDefaultRepeatInterval string
+	AlertmanagerReceiverNameFormat    string
+	AlertmanagerSeverityOrder         []string // Most urgent first, for inhibition
+	AlertmanagerInhibitEqual          []string // Labels an inhibiting alert must share with those it suppresses
 }